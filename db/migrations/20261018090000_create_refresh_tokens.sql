@@ -0,0 +1,20 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+CREATE TABLE refresh_tokens (
+  id SERIAL,
+  token TEXT NOT NULL,
+  family TEXT NOT NULL,
+  expires TIMESTAMP NOT NULL,
+  scope TEXT NOT NULL,
+  used BOOLEAN NOT NULL DEFAULT FALSE,
+  user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX refresh_token_unique_token_idx ON refresh_tokens(token);
+CREATE INDEX refresh_token_family_idx ON refresh_tokens(family);
+CREATE INDEX refresh_token_expires_idx ON refresh_tokens(expires ASC);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+DROP TABLE refresh_tokens;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+ALTER TABLE access_tokens ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+ALTER TABLE access_tokens DROP COLUMN created_at;
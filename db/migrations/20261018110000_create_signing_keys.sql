@@ -0,0 +1,19 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+CREATE TABLE signing_keys (
+  id SERIAL,
+  kid TEXT NOT NULL,
+  algorithm TEXT NOT NULL,
+  private_key TEXT NOT NULL,
+  status TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  rotated_at TIMESTAMP,
+  PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX signing_keys_unique_kid_idx ON signing_keys(kid);
+CREATE UNIQUE INDEX signing_keys_single_active_idx ON signing_keys(status) WHERE status = 'active';
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+DROP TABLE signing_keys;
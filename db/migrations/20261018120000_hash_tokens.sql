@@ -0,0 +1,13 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+-- Tokens are stored as the hex encoded SHA-256 of the value handed to clients,
+-- hashed as UTF-8 like hashToken does. A text::bytea cast would read
+-- backslashes as escapes.
+UPDATE access_tokens SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+UPDATE refresh_tokens SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+-- Hashes can not be turned back into tokens, clients have to log in again.
+DELETE FROM access_tokens;
+DELETE FROM refresh_tokens;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+CREATE TABLE api_clients (
+  id SERIAL,
+  client_id TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  name TEXT NOT NULL,
+  scope TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX api_clients_unique_client_id_idx ON api_clients(client_id);
+CREATE INDEX api_clients_user_id_idx ON api_clients(user_id);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+DROP TABLE api_clients;
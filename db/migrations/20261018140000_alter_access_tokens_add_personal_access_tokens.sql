@@ -0,0 +1,13 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+-- Personal access tokens are access tokens with a name.
+ALTER TABLE access_tokens ADD COLUMN name TEXT;
+ALTER TABLE access_tokens ADD COLUMN last_used_at TIMESTAMP;
+
+CREATE INDEX access_token_personal_user_id_idx ON access_tokens(user_id) WHERE name IS NOT NULL;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+DROP INDEX access_token_personal_user_id_idx;
+ALTER TABLE access_tokens DROP COLUMN last_used_at;
+ALTER TABLE access_tokens DROP COLUMN name;
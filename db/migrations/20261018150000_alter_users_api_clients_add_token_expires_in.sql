@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+ALTER TABLE users ADD COLUMN token_expires_in int;
+ALTER TABLE api_clients ADD COLUMN token_expires_in int;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+ALTER TABLE api_clients DROP COLUMN token_expires_in;
+ALTER TABLE users DROP COLUMN token_expires_in;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+ALTER TABLE api_clients ADD COLUMN redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE authorization_codes (
+  id SERIAL,
+  code TEXT NOT NULL,
+  client_id TEXT NOT NULL REFERENCES api_clients (client_id) ON DELETE CASCADE,
+  redirect_uri TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  code_challenge TEXT NOT NULL,
+  expires TIMESTAMP NOT NULL,
+  user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX authorization_codes_unique_code_idx ON authorization_codes(code);
+CREATE INDEX authorization_codes_expires_idx ON authorization_codes(expires ASC);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+DROP TABLE authorization_codes;
+ALTER TABLE api_clients DROP COLUMN redirect_uris;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+CREATE TABLE password_reset_tokens (
+  id SERIAL,
+  token TEXT NOT NULL,
+  user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  expires TIMESTAMP NOT NULL,
+  PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX password_reset_tokens_unique_token_idx ON password_reset_tokens(token);
+CREATE INDEX password_reset_tokens_user_id_idx ON password_reset_tokens(user_id);
+CREATE INDEX password_reset_tokens_expires_idx ON password_reset_tokens(expires ASC);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+DROP TABLE password_reset_tokens;
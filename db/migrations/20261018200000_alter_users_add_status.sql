@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+
+CREATE INDEX users_status_idx ON users(status);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+DROP INDEX users_status_idx;
+
+ALTER TABLE users DROP COLUMN status;
@@ -0,0 +1,35 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE resources ADD COLUMN deleted_at TIMESTAMP;
+
+-- The email of a deleted user can be taken by a new user.
+DROP INDEX users_unique_lower_email_idx;
+CREATE UNIQUE INDEX users_unique_lower_email_idx ON users(lower(email)) WHERE deleted_at IS NULL;
+
+CREATE INDEX users_deleted_at_idx ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX resources_deleted_at_idx ON resources(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+-- Without deleted_at, soft deleted users and resources would come back as
+-- active ones, and deleting them here would lose what can still be restored.
+-- The rollback is refused until they are restored or purged.
+-- +goose StatementBegin
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users WHERE deleted_at IS NOT NULL) OR EXISTS (SELECT 1 FROM resources WHERE deleted_at IS NOT NULL) THEN
+        RAISE EXCEPTION 'soft deleted users or resources exist, restore or purge them before rolling back';
+    END IF;
+END
+$$;
+-- +goose StatementEnd
+
+DROP INDEX resources_deleted_at_idx;
+DROP INDEX users_deleted_at_idx;
+
+DROP INDEX users_unique_lower_email_idx;
+CREATE UNIQUE INDEX users_unique_lower_email_idx ON users(lower(email));
+
+ALTER TABLE resources DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN deleted_at;
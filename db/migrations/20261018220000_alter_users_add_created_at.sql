@@ -0,0 +1,17 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+ALTER TABLE users ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+-- Indexes of the sort orders and filters of GET /users, users are sorted by
+-- id with the primary key and by email with users_unique_lower_email_idx.
+CREATE INDEX users_created_at_id_idx ON users(created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX users_lower_email_pattern_idx ON users(lower(email) text_pattern_ops) WHERE deleted_at IS NULL;
+CREATE INDEX users_quota_idx ON users(quota) WHERE deleted_at IS NULL;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+DROP INDEX users_quota_idx;
+DROP INDEX users_lower_email_pattern_idx;
+DROP INDEX users_created_at_id_idx;
+
+ALTER TABLE users DROP COLUMN created_at;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+-- Existing clients have a secret, they are confidential and have to
+-- authenticate to exchange authorization codes.
+ALTER TABLE api_clients ADD COLUMN public BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+ALTER TABLE api_clients DROP COLUMN public;
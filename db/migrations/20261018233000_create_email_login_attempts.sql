@@ -0,0 +1,26 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+-- Failed logins are counted per email whether a user has the email or not,
+-- so that lockouts do not tell which emails are registered.
+CREATE TABLE email_login_attempts (
+  email TEXT NOT NULL,
+  failed_login_attempts INT NOT NULL,
+  locked_until TIMESTAMP NOT NULL,
+  PRIMARY KEY (email)
+);
+
+INSERT INTO email_login_attempts (email, failed_login_attempts, locked_until)
+SELECT lower(email), failed_login_attempts, locked_until FROM users WHERE locked_until IS NOT NULL AND deleted_at IS NULL;
+
+ALTER TABLE users DROP COLUMN locked_until;
+ALTER TABLE users DROP COLUMN failed_login_attempts;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+ALTER TABLE users ADD COLUMN failed_login_attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until TIMESTAMP;
+
+UPDATE users SET failed_login_attempts = email_login_attempts.failed_login_attempts, locked_until = email_login_attempts.locked_until
+FROM email_login_attempts WHERE email_login_attempts.email = lower(users.email) AND users.deleted_at IS NULL;
+
+DROP TABLE email_login_attempts;
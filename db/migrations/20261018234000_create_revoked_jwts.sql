@@ -0,0 +1,17 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+-- JWT access tokens are not stored, revoking one records its jti until it
+-- expires, and revoking all tokens of a user records when.
+CREATE TABLE revoked_jwts (
+  jti TEXT NOT NULL,
+  expires TIMESTAMP NOT NULL,
+  PRIMARY KEY (jti)
+);
+
+ALTER TABLE users ADD COLUMN tokens_revoked_at TIMESTAMP;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+ALTER TABLE users DROP COLUMN tokens_revoked_at;
+
+DROP TABLE revoked_jwts;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+-- Access tokens issued with a refresh token belong to its family, revoking
+-- the refresh token revokes them as well.
+ALTER TABLE access_tokens ADD COLUMN family TEXT;
+
+CREATE INDEX access_tokens_family_idx ON access_tokens(family) WHERE family IS NOT NULL;
+
+-- JWT access tokens are not stored, revoking a refresh token records its
+-- family until the access tokens issued with it expired.
+CREATE TABLE revoked_token_families (
+  family TEXT NOT NULL,
+  expires TIMESTAMP NOT NULL,
+  PRIMARY KEY (family)
+);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+DROP TABLE revoked_token_families;
+
+DROP INDEX access_tokens_family_idx;
+ALTER TABLE access_tokens DROP COLUMN family;
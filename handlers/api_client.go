@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+func CreateAPIClientHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("request body is nil"),
+		}
+	}
+
+	var client models.APIClient
+	err := json.NewDecoder(r.Body).Decode(&client)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("failed to parse request body as json, err: %s", err),
+		}
+	}
+	defer r.Body.Close()
+
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := env.UserService.GetUser(*userID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusForbidden,
+			ActualError: fmt.Errorf("access denied"),
+		}
+	}
+
+	scope := models.ParseScope(client.Scope)
+	if len(scope) > 0 {
+		scope, err = delegatedScope(env, user).Narrow(scope)
+		if err != nil {
+			return HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				ActualError: err,
+			}
+		}
+	}
+
+	clientData, err := env.APIClientService.CreateAPIClient(*userID, client.Name, scope, client.TokenExpiresIn, client.RedirectURIs, client.Public)
+	if err != nil {
+		switch err.(type) {
+		case models.APIClientValidationError:
+			return HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				ActualError: err,
+			}
+		default:
+			return err
+		}
+	}
+
+	env.Render.JSON(w, http.StatusCreated, clientData)
+	return nil
+}
+
+func ListAPIClientsHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	clients, err := env.APIClientService.ListAPIClients(*userID)
+	if err != nil {
+		return err
+	}
+
+	env.Render.JSON(w, http.StatusOK, clients)
+	return nil
+}
+
+func DeleteAPIClientHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	vars := mux.Vars(r)
+	clientID := vars["client_id"]
+
+	err = env.APIClientService.DeleteAPIClient(*userID, clientID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("client not found"),
+		}
+	}
+
+	env.Render.Data(w, http.StatusNoContent, nil)
+	return nil
+}
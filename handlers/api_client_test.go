@@ -0,0 +1,363 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestCreateAPIClientHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return 401 if no access token
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/clients", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected := `{"code":401,"message":"access denied"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if request body is nil
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"request body is nil"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if name is missing
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid name: name is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if scope not grantable to api clients of the user
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"ci","scope":"resources:read clients:write"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid scope: 'clients:write'"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if token_expires_in invalid
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"ci","token_expires_in":-1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid token_expires_in: token_expires_in should be between 1 and 86400 seconds"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 201 with the client token lifetime if given
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"ci","token_expires_in":300}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+	expected = `{"client_id":"fakeClientID","client_secret":"fakeClientSecret","name":"ci","token_expires_in":300,"created_at":"2019-01-01T00:00:00Z"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if redirect uri invalid
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"web","redirect_uris":["http://app.example.com/callback"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid redirect_uris: 'http://app.example.com/callback' is not a valid redirect uri"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if redirect uri runs script in the browser
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"web","redirect_uris":["javascript://app.example.com/%0Aalert(1)"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid redirect_uris: 'javascript://app.example.com/%0Aalert(1)' is not a valid redirect uri"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if redirect uri has neither host nor path
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"web","redirect_uris":["com.example.app://"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid redirect_uris: 'com.example.app://' is not a valid redirect uri"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 201 with the redirect uris if given
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"web","redirect_uris":["https://app.example.com/callback","http://localhost:3000/callback","com.example.app:/callback"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+	expected = `{"client_id":"fakeClientID","client_secret":"fakeClientSecret","name":"web","redirect_uris":["https://app.example.com/callback","http://localhost:3000/callback","com.example.app:/callback"],"created_at":"2019-01-01T00:00:00Z"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if public client has no redirect uris
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"web","public":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid redirect_uris: redirect_uris are required for public clients"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 201 without client secret if public client created
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"web","redirect_uris":["https://app.example.com/callback"],"public":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+	expected = `{"client_id":"fakeClientID","name":"web","redirect_uris":["https://app.example.com/callback"],"public":true,"created_at":"2019-01-01T00:00:00Z"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 201 with client secret if client created
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"ci","scope":"resources:read"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+	expected = `{"client_id":"fakeClientID","client_secret":"fakeClientSecret","name":"ci","scope":"resources:read","created_at":"2019-01-01T00:00:00Z"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if api client service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		apiClientServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/clients", strings.NewReader(`{"name":"ci"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestListAPIClientsHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return clients without secrets
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/clients", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `[{"client_id":"client1","name":"ci","scope":"resources:read","created_at":"2019-01-01T00:00:00Z"}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if api client service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		apiClientServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/clients", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+}
+
+func TestDeleteAPIClientHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return 404 if client not found
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("DELETE", "/clients/client2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	expected := `{"code":404,"message":"client not found"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 204 if client deleted
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/clients/client1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNoContent)
+	}
+
+	// Should return 500 if api client service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		apiClientServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/clients/client1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+}
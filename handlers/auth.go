@@ -8,11 +8,11 @@ import (
 	"github.com/moonkeat/chainstack/responses"
 )
 
-func AuthMiddleware(env *Env, path string) func(next http.Handler) http.Handler {
+func AuthMiddleware(env *Env, scope string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			accessToken := strings.TrimSpace(strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", -1))
-			token, err := env.TokenService.AuthenticateToken(accessToken, path)
+			token, err := env.TokenService.AuthenticateToken(accessToken, scope)
 			if err != nil {
 				env.Render.JSON(w, http.StatusUnauthorized, responses.Error{
 					Code:    http.StatusUnauthorized,
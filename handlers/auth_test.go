@@ -0,0 +1,75 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"GET", "/resources", http.StatusOK},
+		{"GET", "/resources/resource1", http.StatusOK},
+		{"DELETE", "/resources/resource1", http.StatusUnauthorized},
+		{"POST", "/resources", http.StatusUnauthorized},
+		{"GET", "/users", http.StatusOK},
+		{"GET", "/users/1", http.StatusOK},
+		{"PATCH", "/users/1", http.StatusUnauthorized},
+		{"DELETE", "/users/1", http.StatusUnauthorized},
+		{"POST", "/users/1/restore", http.StatusUnauthorized},
+		{"POST", "/users", http.StatusUnauthorized},
+		{"PUT", "/users/1/quota", http.StatusUnauthorized},
+		{"DELETE", "/users/1/tokens", http.StatusUnauthorized},
+		{"GET", "/users/1/resources", http.StatusOK},
+		{"POST", "/users/1/resources", http.StatusUnauthorized},
+		{"POST", "/users/1/resources/resource1/restore", http.StatusUnauthorized},
+		{"GET", "/clients", http.StatusUnauthorized},
+		{"POST", "/clients", http.StatusUnauthorized},
+		{"DELETE", "/clients/client1", http.StatusUnauthorized},
+		{"GET", "/tokens", http.StatusUnauthorized},
+		{"POST", "/tokens", http.StatusUnauthorized},
+		{"DELETE", "/tokens/1", http.StatusUnauthorized},
+		{"GET", "/users/1/tokens", http.StatusOK},
+		{"DELETE", "/users/1/tokens/1", http.StatusUnauthorized},
+		{"PUT", "/users/1/token_expires_in", http.StatusUnauthorized},
+		{"POST", "/users/1/unlock", http.StatusUnauthorized},
+		{"POST", "/users/1/suspend", http.StatusUnauthorized},
+		{"POST", "/users/1/reactivate", http.StatusUnauthorized},
+		{"DELETE", "/users/1/mfa", http.StatusUnauthorized},
+		{"POST", "/users/1/password_reset", http.StatusUnauthorized},
+		{"PUT", "/users/me/password", http.StatusUnauthorized},
+		{"PATCH", "/users/me", http.StatusUnauthorized},
+		{"DELETE", "/users/me", http.StatusUnauthorized},
+		{"GET", "/mfa", http.StatusUnauthorized},
+		{"POST", "/mfa", http.StatusUnauthorized},
+		{"DELETE", "/mfa", http.StatusUnauthorized},
+		{"POST", "/mfa/confirm", http.StatusUnauthorized},
+		{"POST", "/mfa/recovery_codes", http.StatusUnauthorized},
+	}
+
+	// Should only allow routes covered by the token scope
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(test.method, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer readonlytoken")
+
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != test.status {
+			t.Errorf("%s %s returned wrong status code: got %v want %v",
+				test.method, test.url, status, test.status)
+		}
+	}
+}
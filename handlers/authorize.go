@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/moonkeat/chainstack/models"
+	"github.com/moonkeat/chainstack/services"
+)
+
+const ResponseTypeCode = "code"
+
+// authorizeCSRFCookie holds the CSRF token of the last sign in page rendered,
+// which the form posts back. Another site can neither read the cookie nor
+// make the browser send it along a cross-site post.
+const authorizeCSRFCookie = "authorize_csrf"
+
+type authorizePage struct {
+	ClientName          string
+	Scope               models.Scope
+	Error               string
+	Email               string
+	ClientID            string
+	RedirectURI         string
+	RequestedScope      string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	CSRFToken           string
+}
+
+// AuthorizeHandler implements the authorization endpoint of the
+// authorization code grant with PKCE. GET renders the sign in and consent
+// page, POST signs the user in and redirects back to the client with a
+// single-use code, or with an error if the user denied the request.
+func AuthorizeHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	r.ParseForm()
+
+	// The consent page must not be framed by another site.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+
+	page := authorizePage{
+		ClientID:            strings.TrimSpace(r.Form.Get("client_id")),
+		RedirectURI:         strings.TrimSpace(r.Form.Get("redirect_uri")),
+		RequestedScope:      r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       strings.TrimSpace(r.Form.Get("code_challenge")),
+		CodeChallengeMethod: strings.TrimSpace(r.Form.Get("code_challenge_method")),
+	}
+
+	// Errors are shown to the user until the redirect uri is known to be
+	// registered, otherwise the endpoint would be an open redirector.
+	client, err := env.APIClientService.GetAPIClient(page.ClientID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows || len(client.RedirectURIs) == 0 {
+		env.Render.HTML(w, http.StatusBadRequest, "authorize_error", authorizePage{Error: "invalid client_id"})
+		return nil
+	}
+
+	if !containsString(client.RedirectURIs, page.RedirectURI) {
+		env.Render.HTML(w, http.StatusBadRequest, "authorize_error", authorizePage{Error: "invalid redirect_uri"})
+		return nil
+	}
+
+	if r.Form.Get("response_type") != ResponseTypeCode {
+		return authorizationRedirect(w, r, page, url.Values{
+			"error":             {"unsupported_response_type"},
+			"error_description": {"response_type should be code"},
+		})
+	}
+
+	if page.CodeChallengeMethod != models.CodeChallengeMethodS256 {
+		return authorizationRedirect(w, r, page, url.Values{
+			"error":             {"invalid_request"},
+			"error_description": {"code_challenge_method should be S256"},
+		})
+	}
+
+	// S256 code challenges are always 43 characters long.
+	if len(page.CodeChallenge) != 43 {
+		return authorizationRedirect(w, r, page, url.Values{
+			"error":             {"invalid_request"},
+			"error_description": {"code_challenge is invalid"},
+		})
+	}
+
+	page.ClientName = client.Name
+	page.Scope = models.ParseScope(page.RequestedScope)
+	if len(page.Scope) == 0 {
+		page.Scope = models.ParseScope(client.Scope)
+	}
+
+	if r.Method == http.MethodGet {
+		return renderAuthorizePage(env, w, r, http.StatusOK, page)
+	}
+
+	// The sign in page must have been rendered for this browser, so that
+	// another site can not post its own form to sign the user in.
+	cookie, err := r.Cookie(authorizeCSRFCookie)
+	if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.Form.Get("csrf_token"))) != 1 {
+		page.Error = "the sign in page expired, try again"
+		return renderAuthorizePage(env, w, r, http.StatusForbidden, page)
+	}
+
+	if r.Form.Get("action") != "allow" {
+		return authorizationRedirect(w, r, page, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"the user denied the request"},
+		})
+	}
+
+	page.Email = strings.TrimSpace(r.Form.Get("email"))
+	user, err := env.UserService.AuthenticateUser(page.Email, r.Form.Get("password"), clientIP(r))
+	if err != nil {
+		switch err := err.(type) {
+		case services.UserLockedError:
+			setRetryAfter(w, err)
+			page.Error = "too many failed login attempts, try again later"
+			return renderAuthorizePage(env, w, r, http.StatusTooManyRequests, page)
+		case services.UserSuspendedError:
+			page.Error = "your account is suspended"
+			return renderAuthorizePage(env, w, r, http.StatusForbidden, page)
+		default:
+			return err
+		}
+	}
+
+	if user == nil {
+		page.Error = "invalid email or password"
+		return renderAuthorizePage(env, w, r, http.StatusUnauthorized, page)
+	}
+
+	err = checkOTP(env, r, user)
+	switch err {
+	case nil:
+	case errOTPRequired:
+		page.Error = "enter the one-time code of your authenticator app"
+		return renderAuthorizePage(env, w, r, http.StatusUnauthorized, page)
+	case errInvalidOTP:
+		page.Error = "invalid one-time code"
+		return renderAuthorizePage(env, w, r, http.StatusUnauthorized, page)
+	default:
+		return err
+	}
+
+	// Third-party apps are delegated credentials like API clients, they can
+	// not manage the credentials of the user.
+	userScope := delegatedScope(env, user)
+	if client.Scope != "" {
+		userScope = models.ParseScope(client.Scope).Intersect(userScope)
+	}
+
+	scope, err := userScope.Narrow(page.Scope)
+	if err != nil {
+		return authorizationRedirect(w, r, page, url.Values{
+			"error":             {"invalid_scope"},
+			"error_description": {err.Error()},
+		})
+	}
+
+	code, err := env.TokenService.CreateAuthorizationCode(DefaultAuthorizationCodeExpiresIn, client.ClientID, page.RedirectURI, scope, page.CodeChallenge, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return authorizationRedirect(w, r, page, url.Values{
+		"code": {code},
+	})
+}
+
+// renderAuthorizePage renders the sign in page with a new CSRF token, set in
+// the cookie the form has to be posted with.
+func renderAuthorizePage(env *Env, w http.ResponseWriter, r *http.Request, status int, page authorizePage) error {
+	page.CSRFToken = uuid.NewV4().String()
+	http.SetCookie(w, &http.Cookie{
+		Name:     authorizeCSRFCookie,
+		Value:    page.CSRFToken,
+		Path:     "/authorize",
+		Secure:   r.TLS != nil || strings.HasPrefix(env.Issuer, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	env.Render.HTML(w, status, "authorize", page)
+	return nil
+}
+
+// authorizationRedirect redirects the user agent back to the registered
+// redirect uri with the given parameters and the state of the request.
+func authorizationRedirect(w http.ResponseWriter, r *http.Request, page authorizePage, params url.Values) error {
+	redirectURI, err := url.Parse(page.RedirectURI)
+	if err != nil {
+		return err
+	}
+
+	query := redirectURI.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if page.State != "" {
+		query.Set("state", page.State)
+	}
+	redirectURI.RawQuery = query.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorizationCodeGrant exchanges a code issued by AuthorizeHandler for an
+// access token and a refresh token. The code verifier proves the client is the
+// one that requested the code.
+func authorizationCodeGrant(env *Env, w http.ResponseWriter, r *http.Request) error {
+	code := strings.TrimSpace(r.Form.Get("code"))
+	if code == "" {
+		return TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidRequest,
+			ActualError: fmt.Errorf("code is required"),
+		}
+	}
+
+	clientID, clientSecret, err := clientCredentials(w, r)
+	if err != nil {
+		return err
+	}
+
+	if clientID == "" {
+		return TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidRequest,
+			ActualError: fmt.Errorf("client_id is required"),
+		}
+	}
+
+	client, err := env.APIClientService.GetAPIClient(clientID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return invalidClientError(w)
+	}
+
+	// Confidential clients authenticate, public clients rely on the code
+	// verifier alone.
+	if !client.Public {
+		authenticatedClient, err := env.APIClientService.AuthenticateAPIClient(clientID, clientSecret)
+		if err != nil {
+			return err
+		}
+
+		if authenticatedClient == nil {
+			return invalidClientError(w)
+		}
+	}
+
+	redirectURI := strings.TrimSpace(r.Form.Get("redirect_uri"))
+	if redirectURI == "" {
+		return TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidRequest,
+			ActualError: fmt.Errorf("redirect_uri is required"),
+		}
+	}
+
+	codeVerifier := strings.TrimSpace(r.Form.Get("code_verifier"))
+	if codeVerifier == "" {
+		return TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidRequest,
+			ActualError: fmt.Errorf("code_verifier is required"),
+		}
+	}
+
+	authorizationCode, err := env.TokenService.ExchangeAuthorizationCode(code, clientID, redirectURI, codeVerifier)
+	if err != nil {
+		switch err.(type) {
+		case services.AuthorizationCodeInvalidError:
+			return TokenError{
+				StatusCode:  http.StatusBadRequest,
+				ErrorCode:   TokenErrorInvalidGrant,
+				ActualError: fmt.Errorf("invalid authorization code"),
+			}
+		default:
+			return err
+		}
+	}
+
+	user, err := env.UserService.GetUser(authorizationCode.UserID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidGrant,
+			ActualError: fmt.Errorf("invalid authorization code"),
+		}
+	}
+
+	if user.Status == models.UserStatusSuspended {
+		return userSuspendedError()
+	}
+
+	scope := models.ParseScope(authorizationCode.Scope)
+	refreshToken, err := env.TokenService.CreateRefreshToken(DefaultRefreshTokenExpiresIn, scope, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return issueToken(env, w, scope, user.ID, tokenExpiresIn(env, user, client), refreshToken)
+}
@@ -0,0 +1,886 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestAuthorizeHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should render an error page if client_id invalid
+	rr := httptest.NewRecorder()
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "unknown")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	req, err := http.NewRequest("GET", "/authorize?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "invalid client_id") {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), "invalid client_id")
+	}
+
+	// Should render an error page if client has no redirect uris
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "client1")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	req, err = http.NewRequest("GET", "/authorize?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "invalid client_id") {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), "invalid client_id")
+	}
+
+	// Should render an error page if redirect_uri not registered
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://evil.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	req, err = http.NewRequest("GET", "/authorize?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "invalid redirect_uri") {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), "invalid redirect_uri")
+	}
+	if location := rr.Header().Get("Location"); location != "" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "")
+	}
+
+	// Should redirect with error if response_type invalid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "token")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	req, err = http.NewRequest("GET", "/authorize?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://app.example.com/callback?error=unsupported_response_type&error_description=response_type+should+be+code&state=xyz" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "https://app.example.com/callback?error=unsupported_response_type&error_description=response_type+should+be+code&state=xyz")
+	}
+
+	// Should redirect with error if code_challenge_method is not S256
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	req, err = http.NewRequest("GET", "/authorize?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://app.example.com/callback?error=invalid_request&error_description=code_challenge_method+should+be+S256&state=xyz" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "https://app.example.com/callback?error=invalid_request&error_description=code_challenge_method+should+be+S256&state=xyz")
+	}
+
+	// Should redirect with error if code_challenge invalid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "short")
+	params.Set("code_challenge_method", "S256")
+	req, err = http.NewRequest("GET", "/authorize?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://app.example.com/callback?error=invalid_request&error_description=code_challenge+is+invalid&state=xyz" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "https://app.example.com/callback?error=invalid_request&error_description=code_challenge+is+invalid&state=xyz")
+	}
+
+	// Should render the sign in page if request valid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("scope", "resources:read")
+	req, err = http.NewRequest("GET", "/authorize?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "Sign in to Web App") {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), "Sign in to Web App")
+	}
+	if !strings.Contains(rr.Body.String(), "<li>resources:read</li>") {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), "<li>resources:read</li>")
+	}
+	if value := rr.Header().Get("Content-Type"); value != "text/html; charset=UTF-8" {
+		t.Errorf("handler returned wrong Content-Type header: got %v want %v",
+			value, "text/html; charset=UTF-8")
+	}
+	if value := rr.Header().Get("X-Frame-Options"); value != "DENY" {
+		t.Errorf("handler returned wrong X-Frame-Options header: got %v want %v",
+			value, "DENY")
+	}
+	var csrfCookie *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == "authorize_csrf" {
+			csrfCookie = cookie
+		}
+	}
+	if csrfCookie == nil || csrfCookie.Value == "" || !csrfCookie.HttpOnly || csrfCookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("handler returned wrong CSRF cookie: got %v", csrfCookie)
+	} else if !strings.Contains(rr.Body.String(), `name="csrf_token" value="`+csrfCookie.Value+`"`) {
+		t.Errorf("handler returned unexpected body: got %v want it to contain the CSRF token %v",
+			rr.Body.String(), csrfCookie.Value)
+	}
+
+	// Should render the sign in page again if the CSRF token is missing or
+	// does not match the cookie
+	for _, cookieValue := range []string{"", "otherCSRFToken"} {
+		rr = httptest.NewRecorder()
+		params = url.Values{}
+		params.Set("response_type", "code")
+		params.Set("client_id", "webapp")
+		params.Set("redirect_uri", "https://app.example.com/callback")
+		params.Set("state", "xyz")
+		params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+		params.Set("code_challenge_method", "S256")
+		params.Set("email", "correct@email.com")
+		params.Set("password", "correctpassword")
+		params.Set("csrf_token", "fakeCSRFToken")
+		params.Set("action", "allow")
+		req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if cookieValue != "" {
+			req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: cookieValue})
+		}
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusForbidden)
+		}
+		if !strings.Contains(rr.Body.String(), "the sign in page expired, try again") {
+			t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+				rr.Body.String(), "the sign in page expired, try again")
+		}
+		if location := rr.Header().Get("Location"); location != "" {
+			t.Errorf("handler returned unexpected location: got %v", location)
+		}
+	}
+
+	// Should redirect with error if user denied the request
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "correct@email.com")
+	params.Set("password", "correctpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "deny")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://app.example.com/callback?error=access_denied&error_description=the+user+denied+the+request&state=xyz" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "https://app.example.com/callback?error=access_denied&error_description=the+user+denied+the+request&state=xyz")
+	}
+
+	// Should render the sign in page again if credential invalid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "correct@email.com")
+	params.Set("password", "wrongpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "allow")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	if !strings.Contains(rr.Body.String(), "invalid email or password") {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), "invalid email or password")
+	}
+	if !strings.Contains(rr.Body.String(), `value="correct@email.com"`) {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), `value="correct@email.com"`)
+	}
+
+	// Should render the sign in page again if two-factor authentication enabled and no otp
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "mfa@email.com")
+	params.Set("password", "correctpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "allow")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	if !strings.Contains(rr.Body.String(), "enter the one-time code of your authenticator app") {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), "enter the one-time code of your authenticator app")
+	}
+
+	// Should render the sign in page again if otp invalid
+	rr = httptest.NewRecorder()
+	params.Set("otp", "654321")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	if !strings.Contains(rr.Body.String(), "invalid one-time code") {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), "invalid one-time code")
+	}
+
+	// Should redirect with code if otp valid
+	rr = httptest.NewRecorder()
+	params.Set("otp", "123456")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://app.example.com/callback?code=fakeAuthorizationCode&state=xyz" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "https://app.example.com/callback?code=fakeAuthorizationCode&state=xyz")
+	}
+
+	// Should render the sign in page again if login locked out
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "locked@email.com")
+	params.Set("password", "correctpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "allow")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusTooManyRequests)
+	}
+	if header := rr.Header().Get("Retry-After"); header != "30" {
+		t.Errorf("handler returned unexpected Retry-After header: got %v want %v", header, "30")
+	}
+	if !strings.Contains(rr.Body.String(), "too many failed login attempts, try again later") {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), "too many failed login attempts, try again later")
+	}
+
+	// Should render the sign in page again if user suspended
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "suspended@email.com")
+	params.Set("password", "correctpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "allow")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+	if !strings.Contains(rr.Body.String(), "your account is suspended") {
+		t.Errorf("handler returned unexpected body: got %v want it to contain %v",
+			rr.Body.String(), "your account is suspended")
+	}
+
+	// Should redirect with error if scope not granted to the user
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "correct@email.com")
+	params.Set("password", "correctpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "allow")
+	params.Set("scope", "users:read")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://app.example.com/callback?error=invalid_scope&error_description=invalid+scope%3A+%27users%3Aread%27&state=xyz" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "https://app.example.com/callback?error=invalid_scope&error_description=invalid+scope%3A+%27users%3Aread%27&state=xyz")
+	}
+
+	// Should redirect with error if scope can manage the credentials of the user
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "correct@email.com")
+	params.Set("password", "correctpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "allow")
+	params.Set("scope", "tokens:write")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://app.example.com/callback?error=invalid_scope&error_description=invalid+scope%3A+%27tokens%3Awrite%27&state=xyz" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "https://app.example.com/callback?error=invalid_scope&error_description=invalid+scope%3A+%27tokens%3Awrite%27&state=xyz")
+	}
+
+	// Should redirect with error if scope outside of client scope
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "restrictedwebapp")
+	params.Set("redirect_uri", "https://app.example.com/callback?tab=1")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "correct@email.com")
+	params.Set("password", "correctpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "allow")
+	params.Set("scope", "resources:write")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://app.example.com/callback?error=invalid_scope&error_description=invalid+scope%3A+%27resources%3Awrite%27&state=xyz&tab=1" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "https://app.example.com/callback?error=invalid_scope&error_description=invalid+scope%3A+%27resources%3Awrite%27&state=xyz&tab=1")
+	}
+
+	// Should redirect with code if user allowed the request
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "correct@email.com")
+	params.Set("password", "correctpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "allow")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://app.example.com/callback?code=fakeAuthorizationCode&state=xyz" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "https://app.example.com/callback?code=fakeAuthorizationCode&state=xyz")
+	}
+
+	// Should redirect with code and keep the query of the redirect uri
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "restrictedwebapp")
+	params.Set("redirect_uri", "https://app.example.com/callback?tab=1")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "correct@email.com")
+	params.Set("password", "correctpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "allow")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://app.example.com/callback?code=fakeAuthorizationCode&tab=1" {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, "https://app.example.com/callback?code=fakeAuthorizationCode&tab=1")
+	}
+
+	// Should return 500 if token service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		tokenServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("state", "xyz")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	params.Set("email", "correct@email.com")
+	params.Set("password", "correctpassword")
+	params.Set("csrf_token", "fakeCSRFToken")
+	params.Set("action", "allow")
+	req, err = http.NewRequest("POST", "/authorize", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "authorize_csrf", Value: "fakeCSRFToken"})
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+}
+
+func TestTokenHandlerAuthorizationCodeGrant(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return 400 if no code
+	rr := httptest.NewRecorder()
+	params := url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("client_id", "publicwebapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("code_verifier", "correctcodeverifier")
+	req, err := http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected := `{"error":"invalid_request","error_description":"code is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if no client_id
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", "correctcode")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("code_verifier", "correctcodeverifier")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_request","error_description":"client_id is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if no redirect_uri
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", "correctcode")
+	params.Set("client_id", "publicwebapp")
+	params.Set("code_verifier", "correctcodeverifier")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_request","error_description":"redirect_uri is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if no code_verifier
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", "correctcode")
+	params.Set("client_id", "publicwebapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_request","error_description":"code_verifier is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if code_verifier does not match
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", "correctcode")
+	params.Set("client_id", "publicwebapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("code_verifier", "wrongcodeverifier")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_grant","error_description":"invalid authorization code"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if code issued to another client
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", "correctcode")
+	params.Set("client_id", "restrictedwebapp")
+	params.Set("client_secret", "correctsecret")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("code_verifier", "correctcodeverifier")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_grant","error_description":"invalid authorization code"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if user suspended
+	handler = fakeHandler(&fakeHandlerOptions{
+		userServiceStatus: "suspended",
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", "correctcode")
+	params.Set("client_id", "publicwebapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("code_verifier", "correctcodeverifier")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_grant","error_description":"user is suspended"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	handler = fakeHandler(nil)
+
+	// Should return 200 with access token and refresh token if code valid for public client
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", "correctcode")
+	params.Set("client_id", "publicwebapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("code_verifier", "correctcodeverifier")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 401 with WWW-Authenticate header if confidential client does not authenticate
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", "correctcode")
+	params.Set("client_id", "webapp")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("code_verifier", "correctcodeverifier")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	if header := rr.Header().Get("WWW-Authenticate"); header != `Basic realm="token"` {
+		t.Errorf("handler returned unexpected WWW-Authenticate header: got %v", header)
+	}
+	expected = `{"error":"invalid_client","error_description":"invalid credentials"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 401 with WWW-Authenticate header if confidential client secret invalid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", "correctcode")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("code_verifier", "correctcodeverifier")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("webapp", "wrongsecret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	if header := rr.Header().Get("WWW-Authenticate"); header != `Basic realm="token"` {
+		t.Errorf("handler returned unexpected WWW-Authenticate header: got %v", header)
+	}
+	expected = `{"error":"invalid_client","error_description":"invalid credentials"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 if confidential client authenticates with basic authentication
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", "correctcode")
+	params.Set("redirect_uri", "https://app.example.com/callback")
+	params.Set("code_verifier", "correctcodeverifier")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("webapp", "correctsecret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
@@ -3,21 +3,40 @@ package handlers
 import (
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 	"github.com/rs/zerolog/log"
 	"github.com/unrolled/render"
 
+	"github.com/moonkeat/chainstack/models"
 	"github.com/moonkeat/chainstack/responses"
 	"github.com/moonkeat/chainstack/services"
 )
 
 type Env struct {
-	Render          *render.Render
-	UserService     services.UserService
-	TokenService    services.TokenService
-	ResourceService services.ResourceService
+	Render           *render.Render
+	UserService      services.UserService
+	TokenService     services.TokenService
+	ResourceService  services.ResourceService
+	APIClientService services.APIClientService
+	MFAService       services.MFAService
+
+	IntrospectionClientID     string
+	IntrospectionClientSecret string
+
+	// Issuer is the base url published by the discovery document, which is
+	// not found when empty.
+	Issuer string
+
+	// TokenExpiresIn is the access token lifetime unless overridden by the
+	// user or the API client, DefaultTokenExpiresIn if zero.
+	TokenExpiresIn time.Duration
+
+	// RequireAdminMFA withholds the users scopes from admins without
+	// two-factor authentication enabled.
+	RequireAdminMFA bool
 }
 
 type Handler struct {
@@ -34,6 +53,18 @@ func (e HandlerError) Error() string {
 	return e.ActualError.Error()
 }
 
+// TokenError is an error of the token endpoint, rendered as an RFC 6749 error
+// response so that OAuth client libraries can parse it.
+type TokenError struct {
+	StatusCode  int
+	ErrorCode   string
+	ActualError error
+}
+
+func (e TokenError) Error() string {
+	return e.ActualError.Error()
+}
+
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := h.H(h.Env, w, r)
 	if err != nil {
@@ -56,6 +87,20 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				Code:    err.StatusCode,
 				Message: err.Error(),
 			})
+		case TokenError:
+			r.ParseForm()
+			log.Debug().
+				Err(err).
+				Int("status_code", err.StatusCode).
+				Str("error_code", err.ErrorCode).
+				Bytes("reqbody", body).
+				Interface("reqForm", r.Form).
+				Str("requrl", r.URL.Path).
+				Msg("Token error.")
+			h.Render.JSON(w, err.StatusCode, responses.TokenError{
+				Error:            err.ErrorCode,
+				ErrorDescription: err.Error(),
+			})
 		default:
 			log.Error().Err(err).Bytes("reqbody", body).Str("requrl", r.URL.Path).Msg("Internal server error.")
 			h.Render.JSON(w, http.StatusInternalServerError, responses.Error{
@@ -71,23 +116,69 @@ func NewHandler(env *Env) http.Handler {
 
 	// authentication
 	r.Handle("/token", Handler{Env: env, H: TokenHandler}).Methods("POST")
+	r.Handle("/authorize", Handler{Env: env, H: AuthorizeHandler}).Methods("GET", "POST")
+	r.Handle("/revoke", Handler{Env: env, H: RevokeTokenHandler}).Methods("POST")
+	r.Handle("/introspect", Handler{Env: env, H: IntrospectTokenHandler}).Methods("POST")
+	r.Handle("/.well-known/jwks.json", Handler{Env: env, H: JWKSHandler}).Methods("GET")
+	r.Handle("/.well-known/oauth-authorization-server", Handler{Env: env, H: AuthorizationServerMetadataHandler}).Methods("GET")
+	r.Handle("/password_reset", Handler{Env: env, H: ResetPasswordHandler}).Methods("POST")
+
+	resourcesRead := alice.New(AuthMiddleware(env, models.ScopeResourcesRead))
+	resourcesWrite := alice.New(AuthMiddleware(env, models.ScopeResourcesWrite))
+	r.Handle("/resources", resourcesRead.Then(Handler{Env: env, H: ListResourcesHandler})).Methods("GET")
+	r.Handle("/resources/{key}", resourcesRead.Then(Handler{Env: env, H: GetResourceHandler})).Methods("GET")
+	r.Handle("/resources/{key}", resourcesWrite.Then(Handler{Env: env, H: DeleteResourceHandler})).Methods("DELETE")
+	r.Handle("/resources", resourcesWrite.Then(Handler{Env: env, H: CreateResourceHandler})).Methods("POST")
+
+	clientsRead := alice.New(AuthMiddleware(env, models.ScopeClientsRead))
+	clientsWrite := alice.New(AuthMiddleware(env, models.ScopeClientsWrite))
+	r.Handle("/clients", clientsRead.Then(Handler{Env: env, H: ListAPIClientsHandler})).Methods("GET")
+	r.Handle("/clients", clientsWrite.Then(Handler{Env: env, H: CreateAPIClientHandler})).Methods("POST")
+	r.Handle("/clients/{client_id}", clientsWrite.Then(Handler{Env: env, H: DeleteAPIClientHandler})).Methods("DELETE")
+
+	tokensRead := alice.New(AuthMiddleware(env, models.ScopeTokensRead))
+	tokensWrite := alice.New(AuthMiddleware(env, models.ScopeTokensWrite))
+	r.Handle("/tokens", tokensRead.Then(Handler{Env: env, H: ListPersonalAccessTokensHandler})).Methods("GET")
+	r.Handle("/tokens", tokensWrite.Then(Handler{Env: env, H: CreatePersonalAccessTokenHandler})).Methods("POST")
+	r.Handle("/tokens/{token_id}", tokensWrite.Then(Handler{Env: env, H: RevokePersonalAccessTokenHandler})).Methods("DELETE")
+
+	// password and two-factor authentication, managed like the other
+	// credentials of the user
+	r.Handle("/users/me/password", tokensWrite.Then(Handler{Env: env, H: ChangePasswordHandler})).Methods("PUT")
+	r.Handle("/mfa", tokensRead.Then(Handler{Env: env, H: GetMFAHandler})).Methods("GET")
+	r.Handle("/mfa", tokensWrite.Then(Handler{Env: env, H: EnrollMFAHandler})).Methods("POST")
+	r.Handle("/mfa", tokensWrite.Then(Handler{Env: env, H: DisableMFAHandler})).Methods("DELETE")
+	r.Handle("/mfa/confirm", tokensWrite.Then(Handler{Env: env, H: ConfirmMFAHandler})).Methods("POST")
+	r.Handle("/mfa/recovery_codes", tokensWrite.Then(Handler{Env: env, H: RegenerateRecoveryCodesHandler})).Methods("POST")
+
+	// the authenticated user, registered before /users/{user_id}
+	r.Handle("/users/me", resourcesRead.Then(Handler{Env: env, H: GetCurrentUserHandler})).Methods("GET")
+	r.Handle("/users/me", tokensWrite.Then(Handler{Env: env, H: UpdateCurrentUserHandler})).Methods("PATCH")
+	r.Handle("/users/me", tokensWrite.Then(Handler{Env: env, H: DeleteCurrentUserHandler})).Methods("DELETE")
 
-	chain := alice.New(AuthMiddleware(env, "resources"))
-	r.Handle("/resources", chain.Then(Handler{Env: env, H: ListResourcesHandler})).Methods("GET")
-	r.Handle("/resources/{key}", chain.Then(Handler{Env: env, H: GetResourceHandler})).Methods("GET")
-	r.Handle("/resources/{key}", chain.Then(Handler{Env: env, H: DeleteResourceHandler})).Methods("DELETE")
-	r.Handle("/resources", chain.Then(Handler{Env: env, H: CreateResourceHandler})).Methods("POST")
-
-	chain = alice.New(AuthMiddleware(env, "users"))
-	r.Handle("/users", chain.Then(Handler{Env: env, H: ListUsersHandler})).Methods("GET")
-	r.Handle("/users/{user_id}", chain.Then(Handler{Env: env, H: GetUserHandler})).Methods("GET")
-	r.Handle("/users/{user_id}", chain.Then(Handler{Env: env, H: DeleteUserHandler})).Methods("DELETE")
-	r.Handle("/users", chain.Then(Handler{Env: env, H: CreateUserHandler})).Methods("POST")
-	r.Handle("/users/{user_id}/quota", chain.Then(Handler{Env: env, H: UpdateUserQuotaHandler})).Methods("PUT")
-	r.Handle("/users/{user_id}/resources", chain.Then(Handler{Env: env, H: ListResourcesHandler})).Methods("GET")
-	r.Handle("/users/{user_id}/resources/{key}", chain.Then(Handler{Env: env, H: GetResourceHandler})).Methods("GET")
-	r.Handle("/users/{user_id}/resources/{key}", chain.Then(Handler{Env: env, H: DeleteResourceHandler})).Methods("DELETE")
-	r.Handle("/users/{user_id}/resources", chain.Then(Handler{Env: env, H: CreateResourceHandler})).Methods("POST")
+	usersRead := alice.New(AuthMiddleware(env, models.ScopeUsersRead))
+	usersWrite := alice.New(AuthMiddleware(env, models.ScopeUsersWrite))
+	r.Handle("/users", usersRead.Then(Handler{Env: env, H: ListUsersHandler})).Methods("GET")
+	r.Handle("/users/{user_id}", usersRead.Then(Handler{Env: env, H: GetUserHandler})).Methods("GET")
+	r.Handle("/users/{user_id}", usersWrite.Then(Handler{Env: env, H: UpdateUserHandler})).Methods("PATCH")
+	r.Handle("/users/{user_id}", usersWrite.Then(Handler{Env: env, H: DeleteUserHandler})).Methods("DELETE")
+	r.Handle("/users/{user_id}/restore", usersWrite.Then(Handler{Env: env, H: RestoreUserHandler})).Methods("POST")
+	r.Handle("/users", usersWrite.Then(Handler{Env: env, H: CreateUserHandler})).Methods("POST")
+	r.Handle("/users/{user_id}/quota", usersWrite.Then(Handler{Env: env, H: UpdateUserQuotaHandler})).Methods("PUT")
+	r.Handle("/users/{user_id}/token_expires_in", usersWrite.Then(Handler{Env: env, H: UpdateUserTokenExpiresInHandler})).Methods("PUT")
+	r.Handle("/users/{user_id}/unlock", usersWrite.Then(Handler{Env: env, H: UnlockUserHandler})).Methods("POST")
+	r.Handle("/users/{user_id}/suspend", usersWrite.Then(Handler{Env: env, H: SuspendUserHandler})).Methods("POST")
+	r.Handle("/users/{user_id}/reactivate", usersWrite.Then(Handler{Env: env, H: ReactivateUserHandler})).Methods("POST")
+	r.Handle("/users/{user_id}/password_reset", usersWrite.Then(Handler{Env: env, H: CreatePasswordResetTokenHandler})).Methods("POST")
+	r.Handle("/users/{user_id}/mfa", usersWrite.Then(Handler{Env: env, H: DisableUserMFAHandler})).Methods("DELETE")
+	r.Handle("/users/{user_id}/tokens", usersRead.Then(Handler{Env: env, H: ListPersonalAccessTokensHandler})).Methods("GET")
+	r.Handle("/users/{user_id}/tokens", usersWrite.Then(Handler{Env: env, H: RevokeUserTokensHandler})).Methods("DELETE")
+	r.Handle("/users/{user_id}/tokens/{token_id}", usersWrite.Then(Handler{Env: env, H: RevokePersonalAccessTokenHandler})).Methods("DELETE")
+	r.Handle("/users/{user_id}/resources", usersRead.Then(Handler{Env: env, H: ListResourcesHandler})).Methods("GET")
+	r.Handle("/users/{user_id}/resources/{key}", usersRead.Then(Handler{Env: env, H: GetResourceHandler})).Methods("GET")
+	r.Handle("/users/{user_id}/resources/{key}", usersWrite.Then(Handler{Env: env, H: DeleteResourceHandler})).Methods("DELETE")
+	r.Handle("/users/{user_id}/resources/{key}/restore", usersWrite.Then(Handler{Env: env, H: RestoreResourceHandler})).Methods("POST")
+	r.Handle("/users/{user_id}/resources", usersWrite.Then(Handler{Env: env, H: CreateResourceHandler})).Methods("POST")
 
 	return r
 }
@@ -4,22 +4,31 @@ import (
 	"database/sql"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/moonkeat/chainstack/handlers"
 	"github.com/moonkeat/chainstack/models"
 	"github.com/moonkeat/chainstack/services"
-	"github.com/unrolled/render"
 )
 
 type fakeHandlerOptions struct {
 	userServiceReturnError                   bool
 	userServiceQuota                         *int
+	userServiceStatus                        string
+	userServiceCountFailedLogins             bool
 	tokenServiceReturnError                  bool
 	resourceServiceCreateReturnError         bool
 	resourceServiceGetResourceError          bool
 	resourceServiceDeleteResourceReturnError bool
 	resourceServiceListResourcesReturnError  bool
+	apiClientServiceReturnError              bool
+	tokenExpiresIn                           time.Duration
+	issuer                                   string
+	noIssuer                                 bool
+	mfaServiceState                          string
+	mfaServiceReturnError                    bool
+	requireAdminMFA                          bool
 }
 
 func fakeHandler(opt *fakeHandlerOptions) http.Handler {
@@ -53,16 +62,61 @@ func fakeHandler(opt *fakeHandlerOptions) http.Handler {
 		resourceServiceListResourcesReturnError = opt.resourceServiceListResourcesReturnError
 	}
 
+	apiClientServiceReturnError := false
+	if opt != nil && opt.apiClientServiceReturnError {
+		apiClientServiceReturnError = opt.apiClientServiceReturnError
+	}
+
 	userServiceQuota := services.UserQuotaUndefined
 	if opt != nil && opt.userServiceQuota != nil {
 		userServiceQuota = *opt.userServiceQuota
 	}
 
+	userServiceStatus := ""
+	if opt != nil {
+		userServiceStatus = opt.userServiceStatus
+	}
+
+	var userServiceFailedLogins map[string]int
+	if opt != nil && opt.userServiceCountFailedLogins {
+		userServiceFailedLogins = map[string]int{}
+	}
+
+	var tokenExpiresIn time.Duration
+	if opt != nil {
+		tokenExpiresIn = opt.tokenExpiresIn
+	}
+
+	issuer := "http://auth.example.com"
+	if opt != nil && opt.issuer != "" {
+		issuer = opt.issuer
+	}
+	if opt != nil && opt.noIssuer {
+		issuer = ""
+	}
+
+	mfaServiceState := ""
+	mfaServiceReturnError := false
+	requireAdminMFA := false
+	if opt != nil {
+		mfaServiceState = opt.mfaServiceState
+		mfaServiceReturnError = opt.mfaServiceReturnError
+		requireAdminMFA = opt.requireAdminMFA
+	}
+
 	return handlers.NewHandler(&handlers.Env{
-		Render: render.New(),
+		Render:                    handlers.NewRender(),
+		IntrospectionClientID:     "introspector",
+		IntrospectionClientSecret: "introspectorsecret",
+		TokenExpiresIn:            tokenExpiresIn,
+		Issuer:                    issuer,
+		RequireAdminMFA:           requireAdminMFA,
 		UserService: &fakeUserService{
 			ReturnError: userServiceReturnError,
 			UserQuota:   userServiceQuota,
+			UserStatus:  userServiceStatus,
+
+			FailedLogins: userServiceFailedLogins,
 		},
 		TokenService: &fakeTokenService{
 			ReturnError: tokenServiceReturnError,
@@ -73,20 +127,42 @@ func fakeHandler(opt *fakeHandlerOptions) http.Handler {
 			DeleteResourceReturnError: resourceServiceDeleteResourceReturnError,
 			ListResourcesReturnError:  resourceServiceListResourcesReturnError,
 		},
+		APIClientService: &fakeAPIClientService{
+			ReturnError: apiClientServiceReturnError,
+		},
+		MFAService: &fakeMFAService{
+			ReturnError: mfaServiceReturnError,
+			State:       mfaServiceState,
+		},
 	})
 }
 
 type fakeUserService struct {
 	ReturnError bool
 	UserQuota   int
+	UserStatus  string
+
+	// FailedLogins counts failed logins per email and locks out emails like
+	// the user service if not nil.
+	FailedLogins map[string]int
 }
 
-func (s fakeUserService) CreateUser(email string, password string, isAdmin bool, quota *int) (*models.User, error) {
+func (s fakeUserService) CreateUser(email string, password string, isAdmin bool, quota *int, tokenExpiresIn *int) (*models.User, error) {
 	if s.ReturnError {
 		return nil, fmt.Errorf("user service error")
 	}
 
-	err := models.ValidateUser(email, password)
+	err := models.ValidateUser(&models.User{
+		Email:          email,
+		Quota:          quota,
+		TokenExpiresIn: tokenExpiresIn,
+		Status:         models.UserStatusActive,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = models.DefaultPasswordRules.ValidatePassword(email, password)
 	if err != nil {
 		return nil, err
 	}
@@ -97,10 +173,11 @@ func (s fakeUserService) CreateUser(email string, password string, isAdmin bool,
 	}
 
 	return &models.User{
-		ID:    1,
-		Email: email,
-		Admin: isAdmin,
-		Quota: quota,
+		ID:             1,
+		Email:          email,
+		Admin:          isAdmin,
+		Quota:          quota,
+		TokenExpiresIn: tokenExpiresIn,
 	}, nil
 }
 
@@ -114,32 +191,149 @@ func (s fakeUserService) GetUser(userID int) (*models.User, error) {
 	}
 
 	return &models.User{
-		ID:    1,
-		Email: "test@test.com",
-		Admin: false,
-		Quota: &s.UserQuota,
+		ID:     1,
+		Email:  "test@test.com",
+		Admin:  false,
+		Quota:  &s.UserQuota,
+		Status: s.UserStatus,
 	}, nil
 }
 
-func (s fakeUserService) UpdateUserQuota(userID int, quota *int) (*models.User, error) {
+func (s fakeUserService) UpdateUser(userID int, update models.UserUpdate) (*models.User, error) {
 	if s.ReturnError {
 		return nil, fmt.Errorf("user service error")
 	}
 
-	if userID == 2 {
+	if userID == 2 || userID < 0 {
 		return nil, sql.ErrNoRows
 	}
 
-	if quota == nil {
+	user := models.User{
+		ID:     userID,
+		Email:  "test@test.com",
+		Admin:  false,
+		Status: models.UserStatusActive,
+	}
+	if s.UserQuota != services.UserQuotaUndefined {
+		quota := s.UserQuota
+		user.Quota = &quota
+	}
+	update.Apply(&user)
+
+	err := models.ValidateUser(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Email == "exists@email.com" {
+		return nil, services.UserEmailExistsError{}
+	}
+
+	if user.Quota == nil {
 		undefinedQuota := services.UserQuotaUndefined
-		quota = &undefinedQuota
+		user.Quota = &undefinedQuota
+	}
+
+	return &user, nil
+}
+
+func (s fakeUserService) UnlockUser(userID int) (*models.User, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("user service error")
+	}
+
+	if userID == 2 {
+		return nil, sql.ErrNoRows
 	}
 
+	quota := services.UserQuotaUndefined
 	return &models.User{
 		ID:    userID,
 		Email: "test@test.com",
 		Admin: false,
-		Quota: quota,
+		Quota: &quota,
+	}, nil
+}
+
+func (s fakeUserService) ChangeUserPassword(userID int, currentPassword string, newPassword string, ip string) error {
+	if s.ReturnError {
+		return fmt.Errorf("user service error")
+	}
+
+	err := models.DefaultPasswordRules.ValidatePassword("test@test.com", newPassword)
+	if err != nil {
+		return err
+	}
+
+	if userID != 1 {
+		return sql.ErrNoRows
+	}
+
+	if currentPassword == "lockedpassword" {
+		return services.UserLockedError{LockedUntil: time.Now().Add(30 * time.Second)}
+	}
+
+	if currentPassword != "correctpassword" {
+		return services.UserPasswordIncorrectError{}
+	}
+
+	return nil
+}
+
+func (s fakeUserService) VerifyUserPassword(userID int, password string, ip string) error {
+	if s.ReturnError {
+		return fmt.Errorf("user service error")
+	}
+
+	if userID != 1 {
+		return sql.ErrNoRows
+	}
+
+	if password == "lockedpassword" {
+		return services.UserLockedError{LockedUntil: time.Now().Add(30 * time.Second)}
+	}
+
+	if password != "correctpassword" {
+		return services.UserPasswordIncorrectError{}
+	}
+
+	return nil
+}
+
+func (s fakeUserService) CreatePasswordResetToken(userID int, expiresIn time.Duration) (*models.PasswordResetToken, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("user service error")
+	}
+
+	if userID != 1 {
+		return nil, sql.ErrNoRows
+	}
+
+	return &models.PasswordResetToken{
+		Token:   "fakeResetToken",
+		UserID:  userID,
+		Expires: time.Unix(1546304400, 0).UTC(),
+	}, nil
+}
+
+func (s fakeUserService) ResetUserPassword(token string, newPassword string) (*models.User, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("user service error")
+	}
+
+	err := models.DefaultPasswordRules.ValidatePassword("test@test.com", newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "fakeResetToken" {
+		return nil, services.PasswordResetTokenInvalidError{}
+	}
+
+	return &models.User{
+		ID:    1,
+		Email: "test@test.com",
+		Quota: &s.UserQuota,
 	}, nil
 }
 
@@ -155,62 +349,337 @@ func (s fakeUserService) DeleteUser(userID int) error {
 	return sql.ErrNoRows
 }
 
-func (s fakeUserService) ListUsers() ([]models.User, error) {
+func (s fakeUserService) RestoreUser(userID int) (*models.User, error) {
 	if s.ReturnError {
 		return nil, fmt.Errorf("user service error")
 	}
 
-	return []models.User{
+	if userID == 3 {
+		return nil, services.UserEmailExistsError{}
+	}
+
+	if userID != 1 {
+		return nil, sql.ErrNoRows
+	}
+
+	user := models.User{
+		ID:     1,
+		Email:  "test@test.com",
+		Admin:  false,
+		Status: models.UserStatusActive,
+	}
+	if s.UserQuota != services.UserQuotaUndefined {
+		quota := s.UserQuota
+		user.Quota = &quota
+	}
+
+	return &user, nil
+}
+
+func (s fakeUserService) PurgeDeletedUsers(retention time.Duration) error {
+	return nil
+}
+
+func (s fakeUserService) ListUsers(options models.UserListOptions) ([]models.User, *models.UserCursor, error) {
+	if s.ReturnError {
+		return nil, nil, fmt.Errorf("user service error")
+	}
+
+	if options.Status == models.UserStatusSuspended {
+		return []models.User{}, nil, nil
+	}
+
+	users := []models.User{}
+	for _, user := range []models.User{
 		{
 			ID:    1,
 			Email: "test@test.com",
 			Admin: false,
 			Quota: &s.UserQuota,
 		},
-	}, nil
+		{
+			ID:    4,
+			Email: "admin@test.com",
+			Admin: true,
+			Quota: &s.UserQuota,
+		},
+	} {
+		if options.Admin != nil && user.Admin != *options.Admin {
+			continue
+		}
+		if !strings.HasPrefix(user.Email, options.EmailPrefix) {
+			continue
+		}
+		if options.Cursor != nil && user.ID <= options.Cursor.ID {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	if options.Limit == 0 || len(users) <= options.Limit {
+		return users, nil, nil
+	}
+
+	users = users[:options.Limit]
+	cursor := models.NewUserCursor(options, users[len(users)-1])
+	return users, &cursor, nil
 }
 
-func (s fakeUserService) AuthenticateUser(email string, password string) (*models.User, error) {
+func (s fakeUserService) AuthenticateUser(email string, password string, ip string) (*models.User, error) {
 	if s.ReturnError {
 		return nil, fmt.Errorf("user service error")
 	}
 
+	if email == "locked@email.com" || (s.FailedLogins != nil && s.FailedLogins[email] >= services.DefaultLoginLockout.MaxAttempts) {
+		return nil, services.UserLockedError{LockedUntil: time.Now().Add(30 * time.Second)}
+	}
+
 	if email == "correct@email.com" && password == "correctpassword" {
 		return &models.User{}, nil
 	}
 
+	if email == "suspended@email.com" && password == "correctpassword" {
+		return nil, services.UserSuspendedError{}
+	}
+
 	if email == "admin@email.com" && password == "adminpassword" {
 		return &models.User{Admin: true}, nil
 	}
 
+	if email == "mfa@email.com" && password == "correctpassword" {
+		return &models.User{ID: 3, Email: email, MFAEnabled: true, FailedLoginAttempts: s.FailedLogins[email]}, nil
+	}
+
+	if email == "mfaadmin@email.com" && password == "adminpassword" {
+		return &models.User{ID: 3, Admin: true, MFAEnabled: true}, nil
+	}
+
+	if email == "shortlived@email.com" && password == "correctpassword" {
+		tokenExpiresIn := 900
+		return &models.User{TokenExpiresIn: &tokenExpiresIn}, nil
+	}
+
 	return nil, nil
 }
 
+func (s fakeUserService) CheckLoginLockout(email string, ip string) error {
+	return nil
+}
+
+func (s fakeUserService) RecordFailedLogin(email string, ip string) error {
+	if s.FailedLogins != nil {
+		s.FailedLogins[email]++
+	}
+	return nil
+}
+
+func (s fakeUserService) ResetFailedLogins(email string) error {
+	delete(s.FailedLogins, email)
+	return nil
+}
+
+func (s fakeUserService) CleanExpiredLoginAttempts() error {
+	return nil
+}
+
+func (s fakeUserService) CleanExpiredPasswordResetTokens() error {
+	return nil
+}
+
 type fakeTokenService struct {
 	ReturnError bool
 }
 
-func (s fakeTokenService) CreateToken(expiresIn time.Duration, scope []string, userID int) (string, error) {
+func (s fakeTokenService) CreateToken(expiresIn time.Duration, scope []string, userID int, family string) (string, error) {
 	if s.ReturnError {
 		return "", fmt.Errorf("token service error")
 	}
+	// Access tokens are only tied to the family of the refresh tokens issued
+	// by the fake.
+	if family != "" && family != "fakeFamily" {
+		return "", fmt.Errorf("unexpected refresh token family: %s", family)
+	}
 	return "fakeToken", nil
 }
 
+func (s fakeTokenService) CreateRefreshToken(expiresIn time.Duration, scope []string, userID int) (*models.RefreshToken, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("token service error")
+	}
+	return &models.RefreshToken{Token: "fakeRefreshToken", Family: "fakeFamily", Scope: strings.Join(scope, " "), UserID: userID}, nil
+}
+
+func (s fakeTokenService) CreatePersonalAccessToken(name string, expiresIn time.Duration, scope []string, userID int) (*models.PersonalAccessToken, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("token service error")
+	}
+
+	err := models.ValidatePersonalAccessToken(name, expiresIn)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt := time.Unix(1546300800, 0).UTC()
+	return &models.PersonalAccessToken{
+		ID:        1,
+		Name:      name,
+		Token:     "fakePersonalAccessToken",
+		Scope:     strings.Join(scope, " "),
+		Expires:   createdAt.Add(expiresIn),
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func (s fakeTokenService) ListPersonalAccessTokens(userID int) ([]models.PersonalAccessToken, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("token service error")
+	}
+
+	lastUsedAt := time.Unix(1546387200, 0).UTC()
+	return []models.PersonalAccessToken{
+		{
+			ID:         1,
+			Name:       "ci",
+			Scope:      "resources:read",
+			Expires:    time.Unix(1548892800, 0).UTC(),
+			CreatedAt:  time.Unix(1546300800, 0).UTC(),
+			LastUsedAt: &lastUsedAt,
+		},
+	}, nil
+}
+
+func (s fakeTokenService) RevokePersonalAccessToken(userID int, tokenID int) error {
+	if s.ReturnError {
+		return fmt.Errorf("token service error")
+	}
+
+	if userID == 1 && tokenID == 1 {
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s fakeTokenService) RotateRefreshToken(refreshToken string, expiresIn time.Duration, scope []string) (*models.RefreshToken, string, error) {
+	if s.ReturnError {
+		return nil, "", fmt.Errorf("token service error")
+	}
+
+	if refreshToken == "correctrefreshtoken" {
+		token := &models.RefreshToken{Family: "fakeFamily", Scope: "resources:read resources:write", UserID: 1}
+		if _, err := models.ParseScope(token.Scope).Narrow(scope); err != nil {
+			return nil, "", err
+		}
+		return token, "fakeRotatedRefreshToken", nil
+	}
+
+	if refreshToken == "adminrefreshtoken" {
+		token := &models.RefreshToken{Family: "fakeFamily", Scope: "resources:read users:read users:write", UserID: 1}
+		if _, err := models.ParseScope(token.Scope).Narrow(scope); err != nil {
+			return nil, "", err
+		}
+		return token, "fakeRotatedRefreshToken", nil
+	}
+
+	if refreshToken == "suspendedrefreshtoken" {
+		return nil, "", services.UserSuspendedError{}
+	}
+
+	return nil, "", services.RefreshTokenInvalidError{}
+}
+
+func (s fakeTokenService) CreateAuthorizationCode(expiresIn time.Duration, clientID string, redirectURI string, scope []string, codeChallenge string, userID int) (string, error) {
+	if s.ReturnError {
+		return "", fmt.Errorf("token service error")
+	}
+
+	return "fakeAuthorizationCode", nil
+}
+
+func (s fakeTokenService) ExchangeAuthorizationCode(code string, clientID string, redirectURI string, codeVerifier string) (*models.AuthorizationCode, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("token service error")
+	}
+
+	if code == "correctcode" && (clientID == "webapp" || clientID == "publicwebapp") && redirectURI == "https://app.example.com/callback" && codeVerifier == "correctcodeverifier" {
+		return &models.AuthorizationCode{
+			Code:        code,
+			ClientID:    clientID,
+			RedirectURI: redirectURI,
+			Scope:       "resources:read resources:write",
+			UserID:      1,
+		}, nil
+	}
+
+	return nil, services.AuthorizationCodeInvalidError{}
+}
+
+func (s fakeTokenService) RevokeToken(token string) error {
+	if s.ReturnError {
+		return fmt.Errorf("token service error")
+	}
+	return nil
+}
+
+func (s fakeTokenService) RevokeUserTokens(userID int) error {
+	if s.ReturnError {
+		return fmt.Errorf("token service error")
+	}
+	return nil
+}
+
 func (s fakeTokenService) CleanExpiredTokens() error {
 	return nil
 }
 
-func (s fakeTokenService) AuthenticateToken(token string, path string) (*models.Token, error) {
-	if token == "tokenwithinvaliduserid" {
-		return &models.Token{UserID: -1}, nil
+func (s fakeTokenService) AuthenticateToken(token string, scope string) (*models.Token, error) {
+	var authenticatedToken *models.Token
+	switch token {
+	case "tokenwithinvaliduserid":
+		authenticatedToken = &models.Token{
+			Scope:  "resources:read resources:write clients:read clients:write tokens:read tokens:write users:read users:write",
+			UserID: -1,
+		}
+	case "correcttoken":
+		authenticatedToken = &models.Token{
+			Token:     token,
+			Scope:     "resources:read resources:write clients:read clients:write tokens:read tokens:write users:read users:write",
+			UserID:    1,
+			Expires:   time.Unix(1546304400, 0),
+			CreatedAt: time.Unix(1546300800, 0),
+		}
+	case "readonlytoken":
+		authenticatedToken = &models.Token{
+			Token:  token,
+			Scope:  "resources:read users:read",
+			UserID: 1,
+		}
+	default:
+		return nil, services.TokenAuthenticationError{}
+	}
+
+	if scope != "" && !models.ParseScope(authenticatedToken.Scope).Contains(scope) {
+		return nil, services.TokenAuthenticationError{}
 	}
 
-	if token == "correcttoken" {
-		return &models.Token{UserID: 1}, nil
+	return authenticatedToken, nil
+}
+
+func (s fakeTokenService) JSONWebKeys() ([]models.JSONWebKey, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("token service error")
 	}
 
-	return nil, services.TokenAuthenticationError{}
+	return []models.JSONWebKey{
+		{
+			KeyType:   "OKP",
+			KeyID:     "key1",
+			Use:       "sig",
+			Algorithm: "EdDSA",
+			Curve:     "Ed25519",
+			X:         "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo",
+		},
+	}, nil
 }
 
 type fakeResourceService struct {
@@ -258,6 +727,25 @@ func (s fakeResourceService) DeleteResource(userID int, key string) error {
 	return sql.ErrNoRows
 }
 
+func (s fakeResourceService) RestoreResource(userID int, key string) (*models.Resource, error) {
+	if s.DeleteResourceReturnError {
+		return nil, fmt.Errorf("resource service error")
+	}
+
+	if key == "resource2" {
+		return &models.Resource{
+			Key:       "resource2",
+			CreatedAt: time.Now().Truncate(24 * time.Hour),
+		}, nil
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s fakeResourceService) PurgeDeletedResources(retention time.Duration) error {
+	return nil
+}
+
 func (s fakeResourceService) ListResources(userID int) ([]models.Resource, error) {
 	if s.ListResourcesReturnError {
 		return nil, fmt.Errorf("resource service error")
@@ -274,3 +762,198 @@ func (s fakeResourceService) ListResources(userID int) ([]models.Resource, error
 
 	return nil, nil
 }
+
+type fakeAPIClientService struct {
+	ReturnError bool
+}
+
+func (s fakeAPIClientService) CreateAPIClient(userID int, name string, scope []string, tokenExpiresIn *int, redirectURIs []string, public bool) (*models.APIClient, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("api client service error")
+	}
+
+	err := models.ValidateAPIClient(name, tokenExpiresIn, redirectURIs, public)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &models.APIClient{
+		ClientID:       "fakeClientID",
+		Secret:         "fakeClientSecret",
+		Name:           name,
+		Scope:          strings.Join(scope, " "),
+		TokenExpiresIn: tokenExpiresIn,
+		RedirectURIs:   redirectURIs,
+		Public:         public,
+		CreatedAt:      time.Unix(1546300800, 0).UTC(),
+		UserID:         userID,
+	}
+	if public {
+		client.Secret = ""
+	}
+
+	return client, nil
+}
+
+func (s fakeAPIClientService) GetAPIClient(clientID string) (*models.APIClient, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("api client service error")
+	}
+
+	switch clientID {
+	case "client1":
+		return &models.APIClient{ClientID: clientID, Name: "ci", UserID: 1}, nil
+	case "webapp":
+		return &models.APIClient{ClientID: clientID, Name: "Web App", RedirectURIs: []string{"https://app.example.com/callback"}, UserID: 1}, nil
+	case "restrictedwebapp":
+		return &models.APIClient{ClientID: clientID, Name: "Web App", Scope: "resources:read", RedirectURIs: []string{"https://app.example.com/callback?tab=1"}, UserID: 1}, nil
+	case "publicwebapp":
+		return &models.APIClient{ClientID: clientID, Name: "Web App", RedirectURIs: []string{"https://app.example.com/callback"}, Public: true, UserID: 1}, nil
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s fakeAPIClientService) ListAPIClients(userID int) ([]models.APIClient, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("api client service error")
+	}
+
+	return []models.APIClient{
+		{
+			ClientID:  "client1",
+			Name:      "ci",
+			Scope:     "resources:read",
+			CreatedAt: time.Unix(1546300800, 0).UTC(),
+			UserID:    userID,
+		},
+	}, nil
+}
+
+func (s fakeAPIClientService) DeleteAPIClient(userID int, clientID string) error {
+	if s.ReturnError {
+		return fmt.Errorf("api client service error")
+	}
+
+	if clientID == "client1" {
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s fakeAPIClientService) AuthenticateAPIClient(clientID string, secret string) (*models.APIClient, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("api client service error")
+	}
+
+	if secret != "correctsecret" {
+		return nil, nil
+	}
+
+	switch clientID {
+	case "client1":
+		return &models.APIClient{ClientID: clientID, UserID: 1}, nil
+	case "restrictedclient":
+		return &models.APIClient{ClientID: clientID, Scope: "resources:read users:read", UserID: 1}, nil
+	case "orphanclient":
+		return &models.APIClient{ClientID: clientID, UserID: 2}, nil
+	case "shortlivedclient":
+		tokenExpiresIn := 300
+		return &models.APIClient{ClientID: clientID, TokenExpiresIn: &tokenExpiresIn, UserID: 1}, nil
+	case "webapp":
+		return &models.APIClient{ClientID: clientID, RedirectURIs: []string{"https://app.example.com/callback"}, UserID: 1}, nil
+	case "restrictedwebapp":
+		return &models.APIClient{ClientID: clientID, Scope: "resources:read", RedirectURIs: []string{"https://app.example.com/callback?tab=1"}, UserID: 1}, nil
+	}
+
+	return nil, nil
+}
+
+// fakeMFAService State is "" for users without two-factor authentication,
+// "enrolled" for users with a secret to confirm, "enabled" for users with
+// two-factor authentication enabled, or "unconfigured" without encryption key.
+type fakeMFAService struct {
+	ReturnError bool
+	State       string
+}
+
+func (s fakeMFAService) EnrollMFA(user *models.User) (*models.MFAEnrollment, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("mfa service error")
+	}
+
+	switch s.State {
+	case "enabled":
+		return nil, services.MFAAlreadyEnabledError{}
+	case "unconfigured":
+		return nil, services.MFANotConfiguredError{}
+	}
+
+	return &models.MFAEnrollment{
+		Secret:     "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP",
+		OTPAuthURI: "otpauth://totp/Chainstack:" + user.Email + "?algorithm=SHA1&digits=6&issuer=Chainstack&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP",
+	}, nil
+}
+
+func (s fakeMFAService) ConfirmMFA(userID int, code string) ([]string, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("mfa service error")
+	}
+
+	switch s.State {
+	case "enabled":
+		return nil, services.MFAAlreadyEnabledError{}
+	case "enrolled":
+		if code != "123456" {
+			return nil, services.MFAInvalidCodeError{}
+		}
+		return []string{"abcdefgh-ijklmnop", "qrstuvwx-yz234567"}, nil
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s fakeMFAService) GetMFA(userID int) (*models.MFA, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("mfa service error")
+	}
+
+	if s.State != "enabled" {
+		return nil, sql.ErrNoRows
+	}
+
+	return &models.MFA{Enabled: true, RecoveryCodesRemaining: 10}, nil
+}
+
+func (s fakeMFAService) DisableMFA(userID int) error {
+	if s.ReturnError {
+		return fmt.Errorf("mfa service error")
+	}
+
+	if userID != 1 || (s.State != "enabled" && s.State != "enrolled") {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s fakeMFAService) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	if s.ReturnError {
+		return nil, fmt.Errorf("mfa service error")
+	}
+
+	if s.State != "enabled" {
+		return nil, sql.ErrNoRows
+	}
+
+	return []string{"abcdefgh-ijklmnop", "qrstuvwx-yz234567"}, nil
+}
+
+func (s fakeMFAService) VerifyMFA(userID int, code string) (bool, error) {
+	if s.ReturnError {
+		return false, fmt.Errorf("mfa service error")
+	}
+
+	return code == "123456" || code == "abcdefgh-ijklmnop", nil
+}
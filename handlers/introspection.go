@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/moonkeat/chainstack/responses"
+	"github.com/moonkeat/chainstack/services"
+)
+
+// IntrospectTokenHandler implements RFC 7662 token introspection for
+// downstream services. Callers authenticate as the introspection client
+// configured in Env, and any token that is unknown, expired or revoked is
+// reported as inactive.
+func IntrospectTokenHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	r.ParseForm()
+
+	if !authenticateIntrospectionClient(env, r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		return HandlerError{
+			StatusCode:  http.StatusUnauthorized,
+			ActualError: fmt.Errorf("invalid client credentials"),
+		}
+	}
+
+	tokenString := strings.TrimSpace(r.Form.Get("token"))
+	if tokenString == "" {
+		return HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			ActualError: fmt.Errorf("token is required"),
+		}
+	}
+
+	token, err := env.TokenService.AuthenticateToken(tokenString, "")
+	if err != nil {
+		switch err.(type) {
+		case services.TokenAuthenticationError:
+			env.Render.JSON(w, http.StatusOK, &responses.Introspection{Active: false})
+			return nil
+		default:
+			return err
+		}
+	}
+
+	env.Render.JSON(w, http.StatusOK, &responses.Introspection{
+		Active:    true,
+		Scope:     token.Scope,
+		TokenType: "bearer",
+		Sub:       strconv.Itoa(token.UserID),
+		Exp:       token.Expires.Unix(),
+		Iat:       token.CreatedAt.Unix(),
+	})
+	return nil
+}
+
+func authenticateIntrospectionClient(env *Env, r *http.Request) bool {
+	if env.IntrospectionClientID == "" || env.IntrospectionClientSecret == "" {
+		return false
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.Form.Get("client_id")
+		clientSecret = r.Form.Get("client_secret")
+	}
+
+	idMatch := subtle.ConstantTimeCompare([]byte(clientID), []byte(env.IntrospectionClientID))
+	secretMatch := subtle.ConstantTimeCompare([]byte(clientSecret), []byte(env.IntrospectionClientSecret))
+	return idMatch&secretMatch == 1
+}
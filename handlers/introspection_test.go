@@ -0,0 +1,116 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestIntrospectTokenHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return 401 if no client credentials
+	rr := httptest.NewRecorder()
+	params := url.Values{}
+	params.Set("token", "correcttoken")
+	req, err := http.NewRequest("POST", "/introspect", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected := `{"code":401,"message":"invalid client credentials"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+	if header := rr.Header().Get("WWW-Authenticate"); header != `Basic realm="introspect"` {
+		t.Errorf("handler returned unexpected WWW-Authenticate header: got %v", header)
+	}
+
+	// Should return 401 if client credentials invalid
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/introspect", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("introspector", "wrongsecret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+
+	// Should return 400 if no token
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/introspect", strings.NewReader(url.Values{}.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("introspector", "introspectorsecret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"token is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return inactive if token invalid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("token", "wrongtoken")
+	req, err = http.NewRequest("POST", "/introspect", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("introspector", "introspectorsecret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"active":false}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return token details if token valid, with client credentials in form body
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("token", "correcttoken")
+	params.Set("client_id", "introspector")
+	params.Set("client_secret", "introspectorsecret")
+	req, err = http.NewRequest("POST", "/introspect", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"active":true,"scope":"resources:read resources:write clients:read clients:write tokens:read tokens:write users:read users:write","token_type":"bearer","sub":"1","exp":1546304400,"iat":1546300800}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/moonkeat/chainstack/responses"
+)
+
+// JWKSHandler publishes the public keys that verify access tokens, the keys
+// list is empty when access tokens are opaque.
+func JWKSHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	keys, err := env.TokenService.JSONWebKeys()
+	if err != nil {
+		return err
+	}
+
+	env.Render.JSON(w, http.StatusOK, &responses.JSONWebKeySet{
+		Keys: keys,
+	})
+	return nil
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/moonkeat/chainstack/models"
+	"github.com/moonkeat/chainstack/responses"
+)
+
+// AuthorizationServerMetadataHandler publishes the RFC 8414 discovery
+// document. Grant types and client authentication methods come from the
+// tables TokenHandler dispatches on, so the document can not drift from what
+// the token endpoint accepts.
+func AuthorizationServerMetadataHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	issuer := env.Issuer
+	if issuer == "" {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("issuer not configured"),
+		}
+	}
+
+	grantTypes := []string{}
+	for _, grant := range tokenGrants {
+		grantTypes = append(grantTypes, grant.GrantType)
+	}
+
+	metadata := &responses.AuthorizationServerMetadata{
+		Issuer:                                 issuer,
+		AuthorizationEndpoint:                  issuer + "/authorize",
+		TokenEndpoint:                          issuer + "/token",
+		JWKSURI:                                issuer + "/.well-known/jwks.json",
+		ScopesSupported:                        models.Scopes,
+		ResponseTypesSupported:                 []string{ResponseTypeCode},
+		GrantTypesSupported:                    grantTypes,
+		TokenEndpointAuthMethodsSupported:      tokenEndpointAuthMethods,
+		RevocationEndpoint:                     issuer + "/revoke",
+		RevocationEndpointAuthMethodsSupported: []string{TokenEndpointAuthMethodNone},
+		CodeChallengeMethodsSupported:          []string{models.CodeChallengeMethodS256},
+	}
+
+	// The introspection endpoint rejects every caller unless configured.
+	if env.IntrospectionClientID != "" && env.IntrospectionClientSecret != "" {
+		metadata.IntrospectionEndpoint = issuer + "/introspect"
+		metadata.IntrospectionEndpointAuthMethodsSupported = []string{
+			TokenEndpointAuthMethodClientSecretBasic,
+			TokenEndpointAuthMethodClientSecretPost,
+		}
+	}
+
+	env.Render.JSON(w, http.StatusOK, metadata)
+	return nil
+}
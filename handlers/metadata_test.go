@@ -0,0 +1,85 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestAuthorizationServerMetadataHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return metadata for the issuer without authentication
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://auth.example.com/.well-known/oauth-authorization-server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `{"issuer":"http://auth.example.com","authorization_endpoint":"http://auth.example.com/authorize","token_endpoint":"http://auth.example.com/token","jwks_uri":"http://auth.example.com/.well-known/jwks.json","scopes_supported":["resources:read","resources:write","users:read","users:write","clients:read","clients:write","tokens:read","tokens:write"],"response_types_supported":["code"],"grant_types_supported":["client_credentials","refresh_token","authorization_code"],"token_endpoint_auth_methods_supported":["client_secret_basic","client_secret_post","none"],"revocation_endpoint":"http://auth.example.com/revoke","revocation_endpoint_auth_methods_supported":["none"],"introspection_endpoint":"http://auth.example.com/introspect","introspection_endpoint_auth_methods_supported":["client_secret_basic","client_secret_post"],"code_challenge_methods_supported":["S256"]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should not take the issuer from the request host or forwarded headers
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://attacker.example.com/.well-known/oauth-authorization-server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "attacker.example.com")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should use the configured issuer
+	handler = fakeHandler(&fakeHandlerOptions{
+		issuer: "https://chainstack.example.com",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://auth.example.com/.well-known/oauth-authorization-server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"issuer":"https://chainstack.example.com","authorization_endpoint":"https://chainstack.example.com/authorize","token_endpoint":"https://chainstack.example.com/token","jwks_uri":"https://chainstack.example.com/.well-known/jwks.json","scopes_supported":["resources:read","resources:write","users:read","users:write","clients:read","clients:write","tokens:read","tokens:write"],"response_types_supported":["code"],"grant_types_supported":["client_credentials","refresh_token","authorization_code"],"token_endpoint_auth_methods_supported":["client_secret_basic","client_secret_post","none"],"revocation_endpoint":"https://chainstack.example.com/revoke","revocation_endpoint_auth_methods_supported":["none"],"introspection_endpoint":"https://chainstack.example.com/introspect","introspection_endpoint_auth_methods_supported":["client_secret_basic","client_secret_post"],"code_challenge_methods_supported":["S256"]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should not find the metadata without a configured issuer
+	handler = fakeHandler(&fakeHandlerOptions{
+		noIssuer: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://auth.example.com/.well-known/oauth-authorization-server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/moonkeat/chainstack/models"
+	"github.com/moonkeat/chainstack/responses"
+	"github.com/moonkeat/chainstack/services"
+)
+
+var (
+	errOTPRequired = fmt.Errorf("otp is required")
+	errInvalidOTP  = fmt.Errorf("invalid otp")
+)
+
+// checkOTP checks the one-time code of a password login, if the user enabled
+// two-factor authentication. Invalid codes count as failed logins so that
+// they can not be guessed either, and the failed logins of the user are only
+// forgotten once the code is valid.
+func checkOTP(env *Env, r *http.Request, user *models.User) error {
+	if !user.MFAEnabled {
+		return nil
+	}
+
+	otp := strings.TrimSpace(r.Form.Get("otp"))
+	if otp == "" {
+		return errOTPRequired
+	}
+
+	err := verifyOTP(env, r, user, otp)
+	if err != nil {
+		return err
+	}
+
+	if user.FailedLoginAttempts > 0 {
+		return env.UserService.ResetFailedLogins(user.Email)
+	}
+
+	return nil
+}
+
+// verifyOTP checks a one-time or recovery code of the user, counting invalid
+// codes as failed logins.
+func verifyOTP(env *Env, r *http.Request, user *models.User, otp string) error {
+	ok, err := env.MFAService.VerifyMFA(user.ID, otp)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if !ok {
+		err = env.UserService.RecordFailedLogin(user.Email, clientIP(r))
+		if err != nil {
+			return err
+		}
+
+		return errInvalidOTP
+	}
+
+	return nil
+}
+
+// mfaServiceError maps the errors of the two-factor authentication service.
+func mfaServiceError(err error) error {
+	switch err.(type) {
+	case services.MFANotConfiguredError:
+		return HandlerError{
+			StatusCode:  http.StatusNotImplemented,
+			ActualError: err,
+		}
+	case services.MFAAlreadyEnabledError:
+		return HandlerError{
+			StatusCode:  http.StatusConflict,
+			ActualError: err,
+		}
+	case services.MFAInvalidCodeError:
+		return HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			ActualError: err,
+		}
+	default:
+		return err
+	}
+}
+
+func GetMFAHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	mfa, err := env.MFAService.GetMFA(*userID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		mfa = &models.MFA{}
+	}
+
+	env.Render.JSON(w, http.StatusOK, mfa)
+	return nil
+}
+
+// EnrollMFAHandler generates a TOTP secret for the authenticated user to add
+// to an authenticator app. Two-factor authentication is enabled once a code is
+// confirmed with ConfirmMFAHandler.
+func EnrollMFAHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := env.UserService.GetUser(*userID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusForbidden,
+			ActualError: fmt.Errorf("access denied"),
+		}
+	}
+
+	enrollment, err := env.MFAService.EnrollMFA(user)
+	if err != nil {
+		return mfaServiceError(err)
+	}
+
+	env.Render.JSON(w, http.StatusCreated, enrollment)
+	return nil
+}
+
+func ConfirmMFAHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("request body is nil"),
+		}
+	}
+
+	var confirmRequest struct {
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&confirmRequest)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("failed to parse request body as json, err: %s", err),
+		}
+	}
+	defer r.Body.Close()
+
+	if strings.TrimSpace(confirmRequest.Code) == "" {
+		return HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			ActualError: fmt.Errorf("code is required"),
+		}
+	}
+
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodes, err := env.MFAService.ConfirmMFA(*userID, confirmRequest.Code)
+	if err != nil && err != sql.ErrNoRows {
+		return mfaServiceError(err)
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("two-factor authentication not enrolled"),
+		}
+	}
+
+	env.Render.JSON(w, http.StatusOK, &responses.RecoveryCodes{
+		RecoveryCodes: recoveryCodes,
+	})
+	return nil
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes of the
+// authenticated user. Recovery codes work as a second factor, so like
+// DisableMFAHandler the user confirms with a one-time or recovery code, or
+// with their password.
+func RegenerateRecoveryCodesHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := confirmMFAChange(env, w, r)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodes, err := env.MFAService.RegenerateRecoveryCodes(user.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("two-factor authentication not enabled"),
+		}
+	}
+
+	env.Render.JSON(w, http.StatusOK, &responses.RecoveryCodes{
+		RecoveryCodes: recoveryCodes,
+	})
+	return nil
+}
+
+// DisableMFAHandler disables two-factor authentication of the authenticated
+// user, or cancels an unconfirmed enrollment. A bearer token alone is not
+// enough, the user confirms with a one-time or recovery code, or with their
+// password. All tokens of the user are revoked, including the one of the
+// request, as they may have been issued to whoever was in possession of the
+// token.
+func DisableMFAHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := confirmMFAChange(env, w, r)
+	if err != nil {
+		return err
+	}
+
+	return disableMFA(env, w, user.ID)
+}
+
+// confirmMFAChange returns the authenticated user once they confirmed a change
+// of their two-factor authentication with the code or the password of the
+// request body. Invalid codes and wrong passwords count as failed logins.
+func confirmMFAChange(env *Env, w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	if r.Body == nil {
+		return nil, HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("request body is nil"),
+		}
+	}
+
+	var confirmRequest struct {
+		Code     string `json:"code"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&confirmRequest)
+	if err != nil {
+		return nil, HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("failed to parse request body as json, err: %s", err),
+		}
+	}
+	defer r.Body.Close()
+
+	confirmRequest.Code = strings.TrimSpace(confirmRequest.Code)
+	if confirmRequest.Code == "" && confirmRequest.Password == "" {
+		return nil, HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			ActualError: fmt.Errorf("code or password is required"),
+		}
+	}
+
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := env.UserService.GetUser(*userID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == sql.ErrNoRows {
+		return nil, HandlerError{
+			StatusCode:  http.StatusForbidden,
+			ActualError: fmt.Errorf("access denied"),
+		}
+	}
+
+	if confirmRequest.Code != "" {
+		err = env.UserService.CheckLoginLockout(user.Email, clientIP(r))
+		if err == nil {
+			err = verifyOTP(env, r, user, confirmRequest.Code)
+		}
+	} else {
+		err = env.UserService.VerifyUserPassword(user.ID, confirmRequest.Password, clientIP(r))
+	}
+	if err != nil {
+		switch err := err.(type) {
+		case services.UserPasswordIncorrectError:
+			return nil, HandlerError{
+				StatusCode:  http.StatusForbidden,
+				ActualError: err,
+			}
+		case services.UserLockedError:
+			setRetryAfter(w, err)
+			return nil, HandlerError{
+				StatusCode:  http.StatusTooManyRequests,
+				ActualError: fmt.Errorf("too many failed login attempts, try again later"),
+			}
+		default:
+			if err == errInvalidOTP {
+				return nil, HandlerError{
+					StatusCode:  http.StatusForbidden,
+					ActualError: services.MFAInvalidCodeError{},
+				}
+			}
+			if err == sql.ErrNoRows {
+				return nil, HandlerError{
+					StatusCode:  http.StatusForbidden,
+					ActualError: fmt.Errorf("access denied"),
+				}
+			}
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// DisableUserMFAHandler disables two-factor authentication of any user, for
+// admins helping a user who lost their device. All tokens of the user are
+// revoked, as they may live on the lost device.
+func DisableUserMFAHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	return disableMFA(env, w, *userID)
+}
+
+func disableMFA(env *Env, w http.ResponseWriter, userID int) error {
+	err := env.MFAService.DisableMFA(userID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("two-factor authentication not enabled"),
+		}
+	}
+
+	err = env.TokenService.RevokeUserTokens(userID)
+	if err != nil {
+		return err
+	}
+
+	env.Render.Data(w, http.StatusNoContent, nil)
+	return nil
+}
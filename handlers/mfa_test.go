@@ -0,0 +1,884 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestGetMFAHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return 401 if access token has no tokens:read scope
+	handler := fakeHandler(nil)
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer readonlytoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected := `{"code":401,"message":"access denied"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if mfa service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with two-factor authentication disabled
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"enabled":false,"recovery_codes_remaining":0}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with two-factor authentication enabled
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"enabled":true,"recovery_codes_remaining":10}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestEnrollMFAHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return 401 if access token has no tokens:write scope
+	handler := fakeHandler(nil)
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer readonlytoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected := `{"code":401,"message":"access denied"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if mfa service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 501 if two-factor authentication is not configured
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "unconfigured",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotImplemented {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotImplemented)
+	}
+	expected = `{"code":501,"message":"two-factor authentication is not configured"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 409 if two-factor authentication already enabled
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+	expected = `{"code":409,"message":"two-factor authentication is already enabled"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 201 with the secret to add to an authenticator app
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+	expected = `{"secret":"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP","otpauth_uri":"otpauth://totp/Chainstack:test@test.com?algorithm=SHA1\u0026digits=6\u0026issuer=Chainstack\u0026period=30\u0026secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestConfirmMFAHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return 400 if request body is nil
+	handler := fakeHandler(nil)
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/mfa/confirm", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected := `{"code":400,"message":"request body is nil"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if request body is not json
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/confirm", strings.NewReader(`code`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"failed to parse request body as json, err: invalid character 'c' looking for beginning of value"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if no code
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enrolled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/confirm", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"code is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 404 if two-factor authentication not enrolled
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/confirm", strings.NewReader(`{"code": "123456"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	expected = `{"code":404,"message":"two-factor authentication not enrolled"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if code invalid
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enrolled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/confirm", strings.NewReader(`{"code": "654321"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid code"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 409 if two-factor authentication already enabled
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/confirm", strings.NewReader(`{"code": "123456"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+	expected = `{"code":409,"message":"two-factor authentication is already enabled"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if mfa service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/confirm", strings.NewReader(`{"code": "123456"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with recovery codes
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enrolled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/confirm", strings.NewReader(`{"code": "123456"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"recovery_codes":["abcdefgh-ijklmnop","qrstuvwx-yz234567"]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestRegenerateRecoveryCodesHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return 400 if request body is nil
+	handler := fakeHandler(nil)
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/mfa/recovery_codes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected := `{"code":400,"message":"request body is nil"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if request body is not json
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/recovery_codes", strings.NewReader(`code`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"failed to parse request body as json, err: invalid character 'c' looking for beginning of value"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if neither code nor password given
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/recovery_codes", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"code or password is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 403 if code is invalid
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/recovery_codes", strings.NewReader(`{"code": "000000"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+	expected = `{"code":403,"message":"invalid code"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 403 if password is incorrect
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/recovery_codes", strings.NewReader(`{"password": "wrongpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+	expected = `{"code":403,"message":"current password is incorrect"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 429 if user is locked out
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/recovery_codes", strings.NewReader(`{"password": "lockedpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusTooManyRequests)
+	}
+	expected = `{"code":429,"message":"too many failed login attempts, try again later"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 404 if two-factor authentication not enabled
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/recovery_codes", strings.NewReader(`{"password": "correctpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	expected = `{"code":404,"message":"two-factor authentication not enabled"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if mfa service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/recovery_codes", strings.NewReader(`{"password": "correctpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with new recovery codes if confirmed with a code
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/recovery_codes", strings.NewReader(`{"code": "123456"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"recovery_codes":["abcdefgh-ijklmnop","qrstuvwx-yz234567"]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with new recovery codes if confirmed with the password
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/mfa/recovery_codes", strings.NewReader(`{"password": "correctpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"recovery_codes":["abcdefgh-ijklmnop","qrstuvwx-yz234567"]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestDisableMFAHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return 400 if request body is nil
+	handler := fakeHandler(nil)
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("DELETE", "/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected := `{"code":400,"message":"request body is nil"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if request body is not json
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/mfa", strings.NewReader(`code`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"failed to parse request body as json, err: invalid character 'c' looking for beginning of value"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if neither code nor password given
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/mfa", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"code or password is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 403 if code is invalid
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/mfa", strings.NewReader(`{"code": "000000"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+	expected = `{"code":403,"message":"invalid code"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 403 if password is incorrect
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/mfa", strings.NewReader(`{"password": "wrongpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+	expected = `{"code":403,"message":"current password is incorrect"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 429 if user is locked out
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/mfa", strings.NewReader(`{"password": "lockedpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusTooManyRequests)
+	}
+	expected = `{"code":429,"message":"too many failed login attempts, try again later"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 404 if two-factor authentication not enabled
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/mfa", strings.NewReader(`{"password": "correctpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	expected = `{"code":404,"message":"two-factor authentication not enabled"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if mfa service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/mfa", strings.NewReader(`{"code": "123456"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 204 if two-factor authentication disabled with a code
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/mfa", strings.NewReader(`{"code": "123456"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNoContent)
+	}
+	expected = ``
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 204 if two-factor authentication disabled with a recovery code
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/mfa", strings.NewReader(`{"code": "abcdefgh-ijklmnop"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNoContent)
+	}
+	expected = ``
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 204 if two-factor authentication disabled with the password
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/mfa", strings.NewReader(`{"password": "correctpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNoContent)
+	}
+	expected = ``
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 404 if admin resets a user without two-factor authentication
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/users/2/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	expected = `{"code":404,"message":"two-factor authentication not enabled"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 204 if admin resets two-factor authentication of a user
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceState: "enabled",
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/users/1/mfa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNoContent)
+	}
+	expected = ``
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/moonkeat/chainstack/models"
+	"github.com/moonkeat/chainstack/services"
+)
+
+// ChangePasswordHandler sets a new password for the authenticated user and
+// revokes all of their tokens, including the one of the request.
+func ChangePasswordHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("request body is nil"),
+		}
+	}
+
+	var changeRequest struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&changeRequest)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("failed to parse request body as json, err: %s", err),
+		}
+	}
+	defer r.Body.Close()
+
+	if changeRequest.CurrentPassword == "" {
+		return HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			ActualError: fmt.Errorf("current_password is required"),
+		}
+	}
+
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	err = env.UserService.ChangeUserPassword(*userID, changeRequest.CurrentPassword, changeRequest.NewPassword, clientIP(r))
+	if err != nil {
+		switch err := err.(type) {
+		case models.UserValidationError:
+			return HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				ActualError: err,
+			}
+		case services.UserPasswordIncorrectError:
+			return HandlerError{
+				StatusCode:  http.StatusForbidden,
+				ActualError: err,
+			}
+		case services.UserLockedError:
+			setRetryAfter(w, err)
+			return HandlerError{
+				StatusCode:  http.StatusTooManyRequests,
+				ActualError: fmt.Errorf("too many failed login attempts, try again later"),
+			}
+		default:
+			if err == sql.ErrNoRows {
+				return HandlerError{
+					StatusCode:  http.StatusForbidden,
+					ActualError: fmt.Errorf("access denied"),
+				}
+			}
+			return err
+		}
+	}
+
+	err = env.TokenService.RevokeUserTokens(*userID)
+	if err != nil {
+		return err
+	}
+
+	env.Render.Data(w, http.StatusNoContent, nil)
+	return nil
+}
+
+// CreatePasswordResetTokenHandler issues a password reset token for an admin
+// to hand to a user who forgot their password.
+func CreatePasswordResetTokenHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	token, err := env.UserService.CreatePasswordResetToken(*userID, models.PasswordResetTokenExpiresIn)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	env.Render.JSON(w, http.StatusCreated, token)
+	return nil
+}
+
+// ResetPasswordHandler sets a new password with a password reset token and
+// revokes all of the tokens of the user. It needs no access token, the reset
+// token authenticates the request.
+func ResetPasswordHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("request body is nil"),
+		}
+	}
+
+	var resetRequest struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&resetRequest)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("failed to parse request body as json, err: %s", err),
+		}
+	}
+	defer r.Body.Close()
+
+	if resetRequest.Token == "" {
+		return HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			ActualError: fmt.Errorf("token is required"),
+		}
+	}
+
+	user, err := env.UserService.ResetUserPassword(resetRequest.Token, resetRequest.NewPassword)
+	if err != nil {
+		switch err.(type) {
+		case models.UserValidationError:
+			return HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				ActualError: err,
+			}
+		case services.PasswordResetTokenInvalidError:
+			return HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				ActualError: fmt.Errorf("invalid password reset token"),
+			}
+		default:
+			return err
+		}
+	}
+
+	err = env.TokenService.RevokeUserTokens(user.ID)
+	if err != nil {
+		return err
+	}
+
+	env.Render.Data(w, http.StatusNoContent, nil)
+	return nil
+}
@@ -0,0 +1,488 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestChangePasswordHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return 401 if access token has no tokens:write scope
+	handler := fakeHandler(nil)
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("PUT", "/users/me/password", strings.NewReader(`{"current_password":"correctpassword","new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer readonlytoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected := `{"code":401,"message":"access denied"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if request body is nil
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", "/users/me/password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"request body is nil"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if request body is not json
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", "/users/me/password", strings.NewReader(`password`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"failed to parse request body as json, err: invalid character 'p' looking for beginning of value"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if current password is missing
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", "/users/me/password", strings.NewReader(`{"new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"current_password is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if new password is too short
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", "/users/me/password", strings.NewReader(`{"current_password":"correctpassword","new_password":"short"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid password: password should be at least 8 characters"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 403 if current password is incorrect
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", "/users/me/password", strings.NewReader(`{"current_password":"wrongpassword","new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+	expected = `{"code":403,"message":"current password is incorrect"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 429 if login locked out
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", "/users/me/password", strings.NewReader(`{"current_password":"lockedpassword","new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusTooManyRequests)
+	}
+	if retryAfter := rr.Header().Get("Retry-After"); retryAfter == "" {
+		t.Errorf("handler returned no Retry-After header")
+	}
+	expected = `{"code":429,"message":"too many failed login attempts, try again later"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if user service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		userServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", "/users/me/password", strings.NewReader(`{"current_password":"correctpassword","new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if token service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		tokenServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", "/users/me/password", strings.NewReader(`{"current_password":"correctpassword","new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 204 if password changed
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", "/users/me/password", strings.NewReader(`{"current_password":"correctpassword","new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNoContent)
+	}
+	expected = ``
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestCreatePasswordResetTokenHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return 401 if access token has no users:write scope
+	handler := fakeHandler(nil)
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/users/1/password_reset", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer readonlytoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected := `{"code":401,"message":"access denied"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 404 if user id is invalid
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/abc/password_reset", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	expected = `{"code":404,"message":"user not found"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 404 if user not found
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/2/password_reset", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	expected = `{"code":404,"message":"user not found"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if user service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		userServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/1/password_reset", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 201 with the password reset token
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/1/password_reset", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+	expected = `{"token":"fakeResetToken","user_id":1,"expires":"2019-01-01T01:00:00Z"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestResetPasswordHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return 400 if request body is nil
+	handler := fakeHandler(nil)
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/password_reset", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected := `{"code":400,"message":"request body is nil"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if request body is not json
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/password_reset", strings.NewReader(`token`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"failed to parse request body as json, err: invalid character 'o' in literal true (expecting 'r')"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if token is missing
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/password_reset", strings.NewReader(`{"new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"token is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if new password is too short
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/password_reset", strings.NewReader(`{"token":"fakeResetToken","new_password":"short"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid password: password should be at least 8 characters"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if token is invalid
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/password_reset", strings.NewReader(`{"token":"wrongtoken","new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"code":400,"message":"invalid password reset token"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if user service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		userServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/password_reset", strings.NewReader(`{"token":"fakeResetToken","new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if token service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		tokenServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/password_reset", strings.NewReader(`{"token":"fakeResetToken","new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 204 if password reset
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/password_reset", strings.NewReader(`{"token":"fakeResetToken","new_password":"newpassword"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNoContent)
+	}
+	expected = ``
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
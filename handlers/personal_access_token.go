@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+const DefaultPersonalAccessTokenExpiresIn = 30 * 24 * time.Hour
+
+func CreatePersonalAccessTokenHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("request body is nil"),
+		}
+	}
+
+	var tokenRequest struct {
+		Name      string `json:"name"`
+		Scope     string `json:"scope"`
+		ExpiresIn *int   `json:"expires_in"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&tokenRequest)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("failed to parse request body as json, err: %s", err),
+		}
+	}
+	defer r.Body.Close()
+
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := env.UserService.GetUser(*userID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusForbidden,
+			ActualError: fmt.Errorf("access denied"),
+		}
+	}
+
+	scope, err := delegatedScope(env, user).Narrow(models.ParseScope(tokenRequest.Scope))
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			ActualError: err,
+		}
+	}
+
+	expiresIn := DefaultPersonalAccessTokenExpiresIn
+	if tokenRequest.ExpiresIn != nil {
+		expiresIn = time.Duration(*tokenRequest.ExpiresIn) * time.Second
+	}
+
+	token, err := env.TokenService.CreatePersonalAccessToken(tokenRequest.Name, expiresIn, scope, *userID)
+	if err != nil {
+		switch err.(type) {
+		case models.TokenValidationError:
+			return HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				ActualError: err,
+			}
+		default:
+			return err
+		}
+	}
+
+	env.Render.JSON(w, http.StatusCreated, token)
+	return nil
+}
+
+func ListPersonalAccessTokensHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	tokens, err := env.TokenService.ListPersonalAccessTokens(*userID)
+	if err != nil {
+		return err
+	}
+
+	env.Render.JSON(w, http.StatusOK, tokens)
+	return nil
+}
+
+func RevokePersonalAccessTokenHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	tokenID, err := strconv.Atoi(mux.Vars(r)["token_id"])
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("token not found"),
+		}
+	}
+
+	err = env.TokenService.RevokePersonalAccessToken(*userID, tokenID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("token not found"),
+		}
+	}
+
+	env.Render.Data(w, http.StatusNoContent, nil)
+	return nil
+}
@@ -16,7 +16,24 @@ func CreateResourceHandler(env *Env, w http.ResponseWriter, r *http.Request) err
 		return err
 	}
 
-	user, err := env.UserService.GetUser(*userID)
+	err = checkResourceQuota(env, *userID)
+	if err != nil {
+		return err
+	}
+
+	resource, err := env.ResourceService.CreateResource(*userID)
+	if err != nil {
+		return err
+	}
+
+	env.Render.JSON(w, http.StatusCreated, resource)
+	return nil
+}
+
+// checkResourceQuota rejects one more resource for the user if it would
+// exceed their quota.
+func checkResourceQuota(env *Env, userID int) error {
+	user, err := env.UserService.GetUser(userID)
 	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
@@ -27,7 +44,7 @@ func CreateResourceHandler(env *Env, w http.ResponseWriter, r *http.Request) err
 		}
 	}
 
-	resources, err := env.ResourceService.ListResources(*userID)
+	resources, err := env.ResourceService.ListResources(userID)
 	if err != nil {
 		return err
 	}
@@ -39,12 +56,6 @@ func CreateResourceHandler(env *Env, w http.ResponseWriter, r *http.Request) err
 		}
 	}
 
-	resource, err := env.ResourceService.CreateResource(*userID)
-	if err != nil {
-		return err
-	}
-
-	env.Render.JSON(w, http.StatusCreated, resource)
 	return nil
 }
 
@@ -95,6 +106,38 @@ func DeleteResourceHandler(env *Env, w http.ResponseWriter, r *http.Request) err
 	return nil
 }
 
+// RestoreResourceHandler undoes the deletion of a resource that was not
+// purged yet. Restored resources count against the quota again, so the quota
+// is checked as for a new resource.
+func RestoreResourceHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	err = checkResourceQuota(env, *userID)
+	if err != nil {
+		return err
+	}
+
+	resource, err := env.ResourceService.RestoreResource(*userID, key)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("deleted resource not found"),
+		}
+	}
+
+	env.Render.JSON(w, http.StatusOK, resource)
+	return nil
+}
+
 func ListResourcesHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
 	userID, err := getUserIDFromRequest(r)
 	if err != nil {
@@ -320,6 +320,178 @@ func TestDeleteResourceHandler(t *testing.T) {
 	}
 }
 
+func TestRestoreResourceHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return 401 if no access token
+	handler := fakeHandler(nil)
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/users/1/resources/resource2/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected := `{"code":401,"message":"access denied"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 401 if access token has no users:write scope
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/1/resources/resource2/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer readonlytoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected = `{"code":401,"message":"access denied"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if user service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		userServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/1/resources/resource2/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if resource service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		resourceServiceDeleteResourceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/1/resources/resource2/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 403 if user not found
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/2/resources/resource2/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+	expected = `{"code":403,"message":"access denied"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 403 if user quota exceeded
+	quota := 1
+	handler = fakeHandler(&fakeHandlerOptions{
+		userServiceQuota: &quota,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/1/resources/resource2/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+	expected = `{"code":403,"message":"resource quota exceeded"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 404 if no deleted resource with the key
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/1/resources/resource1/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	expected = `{"code":404,"message":"deleted resource not found"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with the restored resource
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/users/1/resources/resource2/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	// createdAt returned from fake resource service
+	createdAt := time.Now().Truncate(24 * time.Hour).Format(time.RFC3339Nano)
+	expected = `{"key":"resource2","created_at":"` + createdAt + `"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
 func TestListResourcesHandler(t *testing.T) {
 	zerolog.SetGlobalLevel(zerolog.WarnLevel)
 
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/unrolled/render"
+)
+
+// templates holds the HTML templates of the authorization endpoint. They are
+// compiled into the binary so the server does not depend on its working
+// directory.
+var templates = map[string]string{
+	"templates/authorize.tmpl": `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Sign in to {{.ClientName}}</title>
+</head>
+<body>
+  <h1>Sign in to {{.ClientName}}</h1>
+  {{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+  <p>{{.ClientName}} is requesting access to:</p>
+  <ul>
+    {{range .Scope}}<li>{{.}}</li>{{else}}<li>your resources, and the users you manage if you are an admin</li>{{end}}
+  </ul>
+  <form method="post" action="/authorize">
+    <input type="hidden" name="response_type" value="code">
+    <input type="hidden" name="client_id" value="{{.ClientID}}">
+    <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+    <input type="hidden" name="scope" value="{{.RequestedScope}}">
+    <input type="hidden" name="state" value="{{.State}}">
+    <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+    <input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <p><label>Email <input type="email" name="email" value="{{.Email}}" required autofocus></label></p>
+    <p><label>Password <input type="password" name="password" required></label></p>
+    <p><label>One-time code <input type="text" name="otp" inputmode="numeric" autocomplete="one-time-code"></label> (if two-factor authentication is enabled)</p>
+    <p>
+      <button type="submit" name="action" value="allow">Allow</button>
+      <button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+    </p>
+  </form>
+</body>
+</html>
+`,
+	"templates/authorize_error.tmpl": `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Authorization failed</title>
+</head>
+<body>
+  <h1>Authorization failed</h1>
+  <p>{{.Error}}</p>
+</body>
+</html>
+`,
+}
+
+// NewRender returns the render.Render the handlers expect, with the HTML
+// templates loaded.
+func NewRender() *render.Render {
+	return render.New(render.Options{
+		Asset: func(name string) ([]byte, error) {
+			template, ok := templates[name]
+			if !ok {
+				return nil, fmt.Errorf("template not found: '%s'", name)
+			}
+			return []byte(template), nil
+		},
+		AssetNames: func() []string {
+			names := []string{}
+			for name := range templates {
+				names = append(names, name)
+			}
+			return names
+		},
+	})
+}
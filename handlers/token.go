@@ -1,75 +1,487 @@
 package handlers
 
 import (
+	"database/sql"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/moonkeat/chainstack/models"
 	"github.com/moonkeat/chainstack/responses"
+	"github.com/moonkeat/chainstack/services"
 )
 
 const (
 	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeAuthorizationCode = "authorization_code"
 
-	DefaultTokenExpiresIn = 1 * time.Hour
+	DefaultTokenExpiresIn             = 1 * time.Hour
+	DefaultRefreshTokenExpiresIn      = 30 * 24 * time.Hour
+	DefaultAuthorizationCodeExpiresIn = 1 * time.Minute
+
+	TokenEndpointAuthMethodClientSecretPost  = "client_secret_post"
+	TokenEndpointAuthMethodClientSecretBasic = "client_secret_basic"
+	TokenEndpointAuthMethodNone              = "none"
+
+	TokenErrorInvalidRequest       = "invalid_request"
+	TokenErrorInvalidClient        = "invalid_client"
+	TokenErrorInvalidGrant         = "invalid_grant"
+	TokenErrorUnsupportedGrantType = "unsupported_grant_type"
+	TokenErrorInvalidScope         = "invalid_scope"
 )
 
+type tokenGrant struct {
+	GrantType string
+	Grant     func(env *Env, w http.ResponseWriter, r *http.Request) error
+}
+
+// tokenGrants are the grant types TokenHandler supports, they are published
+// as is by the discovery document.
+var tokenGrants = []tokenGrant{
+	{GrantType: GrantTypeClientCredentials, Grant: clientCredentialsGrant},
+	{GrantType: GrantTypeRefreshToken, Grant: refreshTokenGrant},
+	{GrantType: GrantTypeAuthorizationCode, Grant: authorizationCodeGrant},
+}
+
+// tokenEndpointAuthMethods are the ways clients can authenticate to
+// TokenHandler. Client secrets are sent with HTTP Basic authentication or in
+// the form, and public clients of the authorization code grant send no secret.
+var tokenEndpointAuthMethods = []string{
+	TokenEndpointAuthMethodClientSecretBasic,
+	TokenEndpointAuthMethodClientSecretPost,
+	TokenEndpointAuthMethodNone,
+}
+
+// TokenHandler issues access tokens with the grant type of the request. Errors
+// are RFC 6749 error responses rather than the error response of the rest of
+// the API.
 func TokenHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
 	r.ParseForm()
 
+	// Responses carrying tokens must not be cached.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
 	grantType := r.Form.Get("grant_type")
-	if grantType != GrantTypeClientCredentials {
-		return HandlerError{
+	if grantType == "" {
+		return TokenError{
 			StatusCode:  http.StatusBadRequest,
-			ActualError: fmt.Errorf("invalid grant type: '%s'", grantType),
+			ErrorCode:   TokenErrorInvalidRequest,
+			ActualError: fmt.Errorf("grant_type is required"),
 		}
 	}
 
-	email := strings.TrimSpace(r.Form.Get("client_id"))
-	if email == "" {
-		return HandlerError{
+	for _, grant := range tokenGrants {
+		if grant.GrantType == grantType {
+			return grant.Grant(env, w, r)
+		}
+	}
+
+	return TokenError{
+		StatusCode:  http.StatusBadRequest,
+		ErrorCode:   TokenErrorUnsupportedGrantType,
+		ActualError: fmt.Errorf("invalid grant type: '%s'", grantType),
+	}
+}
+
+// clientCredentials returns the client id and secret of the request, sent
+// either with HTTP Basic authentication or in the form. RFC 6749 encodes Basic
+// credentials with application/x-www-form-urlencoded before base64, so they
+// are decoded again.
+func clientCredentials(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	if r.Header.Get("Authorization") == "" {
+		return strings.TrimSpace(r.Form.Get("client_id")), strings.TrimSpace(r.Form.Get("client_secret")), nil
+	}
+
+	encodedClientID, encodedClientSecret, ok := r.BasicAuth()
+	if !ok {
+		return "", "", invalidClientError(w)
+	}
+
+	clientID, err := url.QueryUnescape(encodedClientID)
+	if err != nil {
+		return "", "", invalidClientError(w)
+	}
+
+	clientSecret, err := url.QueryUnescape(encodedClientSecret)
+	if err != nil {
+		return "", "", invalidClientError(w)
+	}
+
+	// The client_id form field is allowed alongside Basic authentication as
+	// long as it names the same client, a secret in the form is not.
+	formClientID := strings.TrimSpace(r.Form.Get("client_id"))
+	if r.Form.Get("client_secret") != "" || (formClientID != "" && formClientID != clientID) {
+		return "", "", TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidRequest,
+			ActualError: fmt.Errorf("client credentials should be sent with only one authentication method"),
+		}
+	}
+
+	return clientID, clientSecret, nil
+}
+
+// invalidClientError rejects the client authentication of the request. RFC
+// 6749 requires the WWW-Authenticate header on 401 responses of the token
+// endpoint.
+func invalidClientError(w http.ResponseWriter) error {
+	w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	return TokenError{
+		StatusCode:  http.StatusUnauthorized,
+		ErrorCode:   TokenErrorInvalidClient,
+		ActualError: fmt.Errorf("invalid credentials"),
+	}
+}
+
+// userSuspendedError rejects the grants of suspended users. Their credentials
+// are kept, they work again once the user is reactivated.
+func userSuspendedError() error {
+	return TokenError{
+		StatusCode:  http.StatusBadRequest,
+		ErrorCode:   TokenErrorInvalidGrant,
+		ActualError: fmt.Errorf("user is suspended"),
+	}
+}
+
+// clientIP returns the ip address the request was sent from, failed logins
+// are throttled per ip.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// setRetryAfter tells the client when the lockout of the login ends.
+func setRetryAfter(w http.ResponseWriter, err services.UserLockedError) {
+	seconds := int(math.Ceil(time.Until(err.LockedUntil).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+func clientCredentialsGrant(env *Env, w http.ResponseWriter, r *http.Request) error {
+	clientID, clientSecret, err := clientCredentials(w, r)
+	if err != nil {
+		return err
+	}
+
+	if clientID == "" {
+		return TokenError{
 			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidRequest,
 			ActualError: fmt.Errorf("client_id is required"),
 		}
 	}
 
-	password := strings.TrimSpace(r.Form.Get("client_secret"))
-	if password == "" {
-		return HandlerError{
+	if clientSecret == "" {
+		return TokenError{
 			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidRequest,
 			ActualError: fmt.Errorf("client_secret is required"),
 		}
 	}
 
-	authenticatedUser, err := env.UserService.AuthenticateUser(email, password)
+	// Users log in with their email and password, emails always contain an
+	// '@' while generated API client ids never do.
+	if !strings.Contains(clientID, "@") {
+		return apiClientGrant(env, w, r, clientID, clientSecret)
+	}
+
+	authenticatedUser, err := env.UserService.AuthenticateUser(clientID, clientSecret, clientIP(r))
 	if err != nil {
-		return err
+		switch err := err.(type) {
+		case services.UserLockedError:
+			setRetryAfter(w, err)
+			return TokenError{
+				StatusCode:  http.StatusTooManyRequests,
+				ErrorCode:   TokenErrorInvalidClient,
+				ActualError: fmt.Errorf("too many failed login attempts, try again later"),
+			}
+		case services.UserSuspendedError:
+			return userSuspendedError()
+		default:
+			return err
+		}
 	}
 
 	if authenticatedUser == nil {
-		return HandlerError{
+		return invalidClientError(w)
+	}
+
+	err = checkOTP(env, r, authenticatedUser)
+	switch err {
+	case nil:
+	case errOTPRequired:
+		return TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidRequest,
+			ActualError: err,
+		}
+	case errInvalidOTP:
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		return TokenError{
 			StatusCode:  http.StatusUnauthorized,
-			ActualError: fmt.Errorf("invalid credentials"),
+			ErrorCode:   TokenErrorInvalidClient,
+			ActualError: err,
+		}
+	default:
+		return err
+	}
+
+	scope, err := grantedScope(env, authenticatedUser).Narrow(models.ParseScope(r.Form.Get("scope")))
+	if err != nil {
+		return TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidScope,
+			ActualError: err,
 		}
 	}
 
-	scope := []string{"resources"}
-	if authenticatedUser.Admin {
-		scope = append(scope, "users")
+	refreshToken, err := env.TokenService.CreateRefreshToken(DefaultRefreshTokenExpiresIn, scope, authenticatedUser.ID)
+	if err != nil {
+		return err
 	}
 
-	token, err := env.TokenService.CreateToken(DefaultTokenExpiresIn, scope, authenticatedUser.ID)
+	return issueToken(env, w, scope, authenticatedUser.ID, tokenExpiresIn(env, authenticatedUser, nil), refreshToken)
+}
+
+// apiClientGrant issues an access token to an API client on behalf of the
+// user owning it. API clients re-authenticate instead of using refresh tokens.
+func apiClientGrant(env *Env, w http.ResponseWriter, r *http.Request, clientID string, clientSecret string) error {
+	client, err := env.APIClientService.AuthenticateAPIClient(clientID, clientSecret)
 	if err != nil {
 		return err
 	}
 
-	env.Render.JSON(w, http.StatusOK, &responses.Token{
+	if client == nil {
+		return invalidClientError(w)
+	}
+
+	user, err := env.UserService.GetUser(client.UserID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return invalidClientError(w)
+	}
+
+	if user.Status == models.UserStatusSuspended {
+		return userSuspendedError()
+	}
+
+	clientScope := delegatedScope(env, user)
+	if client.Scope != "" {
+		// The user may have lost scopes since the client was created.
+		clientScope = models.ParseScope(client.Scope).Intersect(clientScope)
+	}
+
+	scope, err := clientScope.Narrow(models.ParseScope(r.Form.Get("scope")))
+	if err != nil {
+		return TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidScope,
+			ActualError: err,
+		}
+	}
+
+	return issueToken(env, w, scope, user.ID, tokenExpiresIn(env, user, client), nil)
+}
+
+func refreshTokenGrant(env *Env, w http.ResponseWriter, r *http.Request) error {
+	refreshToken := strings.TrimSpace(r.Form.Get("refresh_token"))
+	if refreshToken == "" {
+		return TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidRequest,
+			ActualError: fmt.Errorf("refresh_token is required"),
+		}
+	}
+
+	requestedScope := models.ParseScope(r.Form.Get("scope"))
+	previousToken, newRefreshToken, err := env.TokenService.RotateRefreshToken(refreshToken, DefaultRefreshTokenExpiresIn, requestedScope)
+	if err != nil {
+		switch err.(type) {
+		case services.RefreshTokenInvalidError:
+			return TokenError{
+				StatusCode:  http.StatusBadRequest,
+				ErrorCode:   TokenErrorInvalidGrant,
+				ActualError: fmt.Errorf("invalid refresh token"),
+			}
+		case models.ScopeValidationError:
+			return TokenError{
+				StatusCode:  http.StatusBadRequest,
+				ErrorCode:   TokenErrorInvalidScope,
+				ActualError: err,
+			}
+		case services.UserSuspendedError:
+			return userSuspendedError()
+		default:
+			return err
+		}
+	}
+
+	// RotateRefreshToken already rejected scopes outside of the refresh token.
+	scope, _ := models.ParseScope(previousToken.Scope).Narrow(requestedScope)
+
+	user, err := env.UserService.GetUser(previousToken.UserID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return TokenError{
+			StatusCode:  http.StatusBadRequest,
+			ErrorCode:   TokenErrorInvalidGrant,
+			ActualError: fmt.Errorf("invalid refresh token"),
+		}
+	}
+
+	if user.Status == models.UserStatusSuspended {
+		return userSuspendedError()
+	}
+
+	// Scopes the user lost since the refresh token was issued, such as the
+	// users scopes of a former admin, are not renewed.
+	scope = scope.Intersect(grantedScope(env, user))
+
+	return issueToken(env, w, scope, user.ID, tokenExpiresIn(env, user, nil), &models.RefreshToken{
+		Token:  newRefreshToken,
+		Family: previousToken.Family,
+	})
+}
+
+// grantedScope returns every scope the user can be granted. With
+// RequireAdminMFA, admins are only granted the users scopes once two-factor
+// authentication is enabled, until then they can sign in to enable it.
+func grantedScope(env *Env, user *models.User) models.Scope {
+	scope := models.Scope{
+		models.ScopeResourcesRead, models.ScopeResourcesWrite,
+		models.ScopeClientsRead, models.ScopeClientsWrite,
+		models.ScopeTokensRead, models.ScopeTokensWrite,
+	}
+	if user.Admin && (user.MFAEnabled || !env.RequireAdminMFA) {
+		scope = append(scope, models.ScopeUsersRead, models.ScopeUsersWrite)
+	}
+
+	return scope
+}
+
+// delegatedScope returns every scope that API clients and personal access
+// tokens of the user can be granted. They can not manage credentials,
+// otherwise a restricted credential could create itself a broader one.
+func delegatedScope(env *Env, user *models.User) models.Scope {
+	return grantedScope(env, user).Without(
+		models.ScopeClientsRead, models.ScopeClientsWrite,
+		models.ScopeTokensRead, models.ScopeTokensWrite,
+	)
+}
+
+// tokenExpiresIn returns the access token lifetime, the API client setting
+// takes precedence over the user setting, which takes precedence over the
+// server setting.
+func tokenExpiresIn(env *Env, user *models.User, client *models.APIClient) time.Duration {
+	if client != nil && client.TokenExpiresIn != nil {
+		return time.Duration(*client.TokenExpiresIn) * time.Second
+	}
+
+	if user.TokenExpiresIn != nil {
+		return time.Duration(*user.TokenExpiresIn) * time.Second
+	}
+
+	if env.TokenExpiresIn > 0 {
+		return env.TokenExpiresIn
+	}
+
+	return DefaultTokenExpiresIn
+}
+
+// issueToken issues an access token along with the refresh token, if any, and
+// ties it to the refresh token family so that they are revoked together.
+func issueToken(env *Env, w http.ResponseWriter, scope models.Scope, userID int, expiresIn time.Duration, refreshToken *models.RefreshToken) error {
+	family := ""
+	if refreshToken != nil {
+		family = refreshToken.Family
+	}
+
+	token, err := env.TokenService.CreateToken(expiresIn, scope, userID, family)
+	if err != nil {
+		return err
+	}
+
+	response := &responses.Token{
 		AccessToken: token,
 		TokenType:   "bearer",
-		ExpiresIn:   int(DefaultTokenExpiresIn.Seconds()),
-		Scope:       strings.Join(scope, " "),
-	})
+		ExpiresIn:   int(expiresIn.Seconds()),
+		Scope:       scope.String(),
+	}
+	if refreshToken != nil {
+		response.RefreshToken = refreshToken.Token
+	}
+
+	env.Render.JSON(w, http.StatusOK, response)
+
+	return nil
+}
+
+// RevokeTokenHandler implements RFC 7009 token revocation. Possession of the
+// token is enough to revoke it, and unknown tokens are reported as revoked so
+// that callers cannot probe for valid tokens.
+func RevokeTokenHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	r.ParseForm()
+
+	token := strings.TrimSpace(r.Form.Get("token"))
+	if token == "" {
+		return HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			ActualError: fmt.Errorf("token is required"),
+		}
+	}
+
+	err := env.TokenService.RevokeToken(token)
+	if err != nil {
+		return err
+	}
+
+	env.Render.Data(w, http.StatusOK, nil)
+	return nil
+}
+
+func RevokeUserTokensHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	_, err = env.UserService.GetUser(*userID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	err = env.TokenService.RevokeUserTokens(*userID)
+	if err != nil {
+		return err
+	}
 
+	env.Render.Data(w, http.StatusNoContent, nil)
 	return nil
 }
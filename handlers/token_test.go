@@ -6,8 +6,11 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
+
+	"github.com/moonkeat/chainstack/services"
 )
 
 func TestTokenHandler(t *testing.T) {
@@ -28,7 +31,7 @@ func TestTokenHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusBadRequest)
 	}
-	expected := `{"code":400,"message":"invalid grant type: ''"}`
+	expected := `{"error":"invalid_request","error_description":"grant_type is required"}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -37,7 +40,7 @@ func TestTokenHandler(t *testing.T) {
 	// Should return 400 if grant_type invalid
 	rr = httptest.NewRecorder()
 	params = url.Values{}
-	params.Set("grant_type", "authorization_code")
+	params.Set("grant_type", "password")
 	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
 	if err != nil {
 		t.Fatal(err)
@@ -48,7 +51,7 @@ func TestTokenHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusBadRequest)
 	}
-	expected = `{"code":400,"message":"invalid grant type: 'authorization_code'"}`
+	expected = `{"error":"unsupported_grant_type","error_description":"invalid grant type: 'password'"}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -69,7 +72,7 @@ func TestTokenHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusBadRequest)
 	}
-	expected = `{"code":400,"message":"client_id is required"}`
+	expected = `{"error":"invalid_request","error_description":"client_id is required"}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -91,7 +94,7 @@ func TestTokenHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusBadRequest)
 	}
-	expected = `{"code":400,"message":"client_secret is required"}`
+	expected = `{"error":"invalid_request","error_description":"client_secret is required"}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -113,7 +116,32 @@ func TestTokenHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusUnauthorized)
 	}
-	expected = `{"code":401,"message":"invalid credentials"}`
+	expected = `{"error":"invalid_client","error_description":"invalid credentials"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 429 with Retry-After header if login locked out
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "locked@email.com")
+	params.Set("client_secret", "correctpassword")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusTooManyRequests)
+	}
+	if header := rr.Header().Get("Retry-After"); header != "30" {
+		t.Errorf("handler returned unexpected Retry-After header: got %v want %v", header, "30")
+	}
+	expected = `{"error":"invalid_client","error_description":"too many failed login attempts, try again later"}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -186,7 +214,13 @@ func TestTokenHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusOK)
 	}
-	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"scope":"resources"}`
+	if header := rr.Header().Get("Cache-Control"); header != "no-store" {
+		t.Errorf("handler returned unexpected Cache-Control header: got %v", header)
+	}
+	if header := rr.Header().Get("Pragma"); header != "no-cache" {
+		t.Errorf("handler returned unexpected Pragma header: got %v", header)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write clients:read clients:write tokens:read tokens:write"}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -208,7 +242,53 @@ func TestTokenHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusOK)
 	}
-	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"scope":"resources users"}`
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write clients:read clients:write tokens:read tokens:write users:read users:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with requested scope if scope within granted scope
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "admin@email.com")
+	params.Set("client_secret", "adminpassword")
+	params.Set("scope", "users:read resources:read")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRefreshToken","scope":"users:read resources:read"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if scope not granted to the user
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "correct@email.com")
+	params.Set("client_secret", "correctpassword")
+	params.Set("scope", "resources:read users:read")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_scope","error_description":"invalid scope: 'users:read'"}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -239,3 +319,1093 @@ func TestTokenHandler(t *testing.T) {
 			rr.Body.String(), expected)
 	}
 }
+
+func TestTokenHandlerRefreshTokenGrant(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return 400 if no refresh_token
+	rr := httptest.NewRecorder()
+	params := url.Values{}
+	params.Set("grant_type", "refresh_token")
+	req, err := http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected := `{"error":"invalid_request","error_description":"refresh_token is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if refresh_token invalid or already used
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", "usedrefreshtoken")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_grant","error_description":"invalid refresh token"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with rotated refresh token if refresh_token valid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", "correctrefreshtoken")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRotatedRefreshToken","scope":"resources:read resources:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 without the scopes the user is no longer granted
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", "adminrefreshtoken")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRotatedRefreshToken","scope":"resources:read"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with narrower scope if requested
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", "correctrefreshtoken")
+	params.Set("scope", "resources:read")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRotatedRefreshToken","scope":"resources:read"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if requested scope wider than refresh token scope
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", "correctrefreshtoken")
+	params.Set("scope", "users:write")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_scope","error_description":"invalid scope: 'users:write'"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if token service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		tokenServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", "correctrefreshtoken")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestRevokeTokenHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return 400 if no token
+	rr := httptest.NewRecorder()
+	params := url.Values{}
+	req, err := http.NewRequest("POST", "/revoke", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected := `{"code":400,"message":"token is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with no body if token revoked
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("token", "correcttoken")
+	params.Set("token_type_hint", "access_token")
+	req, err = http.NewRequest("POST", "/revoke", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = ``
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if token service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		tokenServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("token", "correcttoken")
+	req, err = http.NewRequest("POST", "/revoke", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestRevokeUserTokensHandler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return 401 if no access token
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("DELETE", "/users/1/tokens", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected := `{"code":401,"message":"access denied"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 404 if user not found
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/users/2/tokens", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+	expected = `{"code":404,"message":"user not found"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 204 if tokens revoked
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/users/1/tokens", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNoContent)
+	}
+
+	// Should return 500 if token service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		tokenServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/users/1/tokens", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer correcttoken")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestTokenHandlerAPIClient(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return 401 if client secret invalid
+	rr := httptest.NewRecorder()
+	params := url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "client1")
+	params.Set("client_secret", "wrongsecret")
+	req, err := http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected := `{"error":"invalid_client","error_description":"invalid credentials"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 401 if client owner no longer exists
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "orphanclient")
+	params.Set("client_secret", "correctsecret")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	expected = `{"error":"invalid_client","error_description":"invalid credentials"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 without refresh token and client management scopes
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "client1")
+	params.Set("client_secret", "correctsecret")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"scope":"resources:read resources:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 with client scope limited to what the user is granted
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "restrictedclient")
+	params.Set("client_secret", "correctsecret")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"scope":"resources:read"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if requested scope outside of client scope
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "restrictedclient")
+	params.Set("client_secret", "correctsecret")
+	params.Set("scope", "resources:write")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_scope","error_description":"invalid scope: 'resources:write'"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if api client service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		apiClientServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "client1")
+	params.Set("client_secret", "correctsecret")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestTokenHandlerBasicAuth(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return 200 if user credentials sent with basic authentication
+	rr := httptest.NewRecorder()
+	params := url.Values{}
+	params.Set("grant_type", "client_credentials")
+	req, err := http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape("correct@email.com"), "correctpassword")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write clients:read clients:write tokens:read tokens:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 if API client credentials sent with basic authentication
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("client1", "correctsecret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"scope":"resources:read resources:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 if client_id form field matches basic authentication
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "client1")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("client1", "correctsecret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"scope":"resources:read resources:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 401 with WWW-Authenticate header if basic credentials invalid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("client1", "wrongsecret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	if header := rr.Header().Get("WWW-Authenticate"); header != `Basic realm="token"` {
+		t.Errorf("handler returned unexpected WWW-Authenticate header: got %v", header)
+	}
+	expected = `{"error":"invalid_client","error_description":"invalid credentials"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 401 with WWW-Authenticate header if basic credentials not url-encoded
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("client1", "correct%secret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	if header := rr.Header().Get("WWW-Authenticate"); header != `Basic realm="token"` {
+		t.Errorf("handler returned unexpected WWW-Authenticate header: got %v", header)
+	}
+	expected = `{"error":"invalid_client","error_description":"invalid credentials"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 401 with WWW-Authenticate header if authorization header malformed
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Basic not-base64")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	if header := rr.Header().Get("WWW-Authenticate"); header != `Basic realm="token"` {
+		t.Errorf("handler returned unexpected WWW-Authenticate header: got %v", header)
+	}
+	expected = `{"error":"invalid_client","error_description":"invalid credentials"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 401 with WWW-Authenticate header if form credentials invalid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "wrong@email.com")
+	params.Set("client_secret", "wrongpassword")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	if header := rr.Header().Get("WWW-Authenticate"); header != `Basic realm="token"` {
+		t.Errorf("handler returned unexpected WWW-Authenticate header: got %v", header)
+	}
+	expected = `{"error":"invalid_client","error_description":"invalid credentials"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if client_secret sent with both authentication methods
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_secret", "correctsecret")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("client1", "correctsecret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_request","error_description":"client credentials should be sent with only one authentication method"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if client_id form field does not match basic authentication
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "client2")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("client1", "correctsecret")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_request","error_description":"client credentials should be sent with only one authentication method"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestTokenHandlerMFA(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(nil)
+
+	// Should return 400 if two-factor authentication enabled and no otp
+	rr := httptest.NewRecorder()
+	params := url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "mfa@email.com")
+	params.Set("client_secret", "correctpassword")
+	req, err := http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected := `{"error":"invalid_request","error_description":"otp is required"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 401 if otp invalid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "mfa@email.com")
+	params.Set("client_secret", "correctpassword")
+	params.Set("otp", "654321")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnauthorized)
+	}
+	if header := rr.Header().Get("WWW-Authenticate"); header != `Basic realm="token"` {
+		t.Errorf("handler returned unexpected WWW-Authenticate header: got %v", header)
+	}
+	expected = `{"error":"invalid_client","error_description":"invalid otp"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 if otp valid
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "mfa@email.com")
+	params.Set("client_secret", "correctpassword")
+	params.Set("otp", "123456")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write clients:read clients:write tokens:read tokens:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 200 if otp is a recovery code
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "mfa@email.com")
+	params.Set("client_secret", "correctpassword")
+	params.Set("otp", "abcdefgh-ijklmnop")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write clients:read clients:write tokens:read tokens:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 500 if mfa service error
+	handler = fakeHandler(&fakeHandlerOptions{
+		mfaServiceReturnError: true,
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "mfa@email.com")
+	params.Set("client_secret", "correctpassword")
+	params.Set("otp", "123456")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	expected = `{"code":500,"message":"internal server error"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should not grant users scopes to admins without two-factor authentication if required
+	handler = fakeHandler(&fakeHandlerOptions{
+		requireAdminMFA: true,
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "admin@email.com")
+	params.Set("client_secret", "adminpassword")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write clients:read clients:write tokens:read tokens:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should grant users scopes to admins with two-factor authentication if required
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "mfaadmin@email.com")
+	params.Set("client_secret", "adminpassword")
+	params.Set("otp", "123456")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write clients:read clients:write tokens:read tokens:write users:read users:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestTokenHandlerMFALockout(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	handler := fakeHandler(&fakeHandlerOptions{
+		userServiceCountFailedLogins: true,
+	})
+	login := func(otp string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		params := url.Values{}
+		params.Set("grant_type", "client_credentials")
+		params.Set("client_id", "mfa@email.com")
+		params.Set("client_secret", "correctpassword")
+		params.Set("otp", otp)
+		req, err := http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Should keep counting invalid otps after the correct password
+	for i := 1; i < services.DefaultLoginLockout.MaxAttempts; i++ {
+		if status := login("654321").Code; status != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code for invalid otp %d: got %v want %v",
+				i, status, http.StatusUnauthorized)
+		}
+	}
+
+	// Should forget the invalid otps once an otp is valid
+	if status := login("123456").Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	// Should return 429 once the invalid otps reach the max attempts
+	for i := 1; i <= services.DefaultLoginLockout.MaxAttempts; i++ {
+		if status := login("654321").Code; status != http.StatusUnauthorized {
+			t.Fatalf("handler returned wrong status code for invalid otp %d: got %v want %v",
+				i, status, http.StatusUnauthorized)
+		}
+	}
+	rr := login("654321")
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusTooManyRequests)
+	}
+	expected := `{"error":"invalid_client","error_description":"too many failed login attempts, try again later"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 429 with a valid otp as well
+	if status := login("123456").Code; status != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusTooManyRequests)
+	}
+}
+
+func TestTokenHandlerTokenExpiresIn(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return the server token lifetime if configured
+	handler := fakeHandler(&fakeHandlerOptions{
+		tokenExpiresIn: 2 * time.Hour,
+	})
+	rr := httptest.NewRecorder()
+	params := url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "correct@email.com")
+	params.Set("client_secret", "correctpassword")
+	req, err := http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `{"access_token":"fakeToken","token_type":"bearer","expires_in":7200,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write clients:read clients:write tokens:read tokens:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return the user token lifetime over the server token lifetime
+	handler = fakeHandler(&fakeHandlerOptions{
+		tokenExpiresIn: 2 * time.Hour,
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "shortlived@email.com")
+	params.Set("client_secret", "correctpassword")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":900,"refresh_token":"fakeRefreshToken","scope":"resources:read resources:write clients:read clients:write tokens:read tokens:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return the client token lifetime over the server token lifetime
+	handler = fakeHandler(&fakeHandlerOptions{
+		tokenExpiresIn: 2 * time.Hour,
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "shortlivedclient")
+	params.Set("client_secret", "correctsecret")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":300,"scope":"resources:read resources:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return the default token lifetime if not configured
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "client1")
+	params.Set("client_secret", "correctsecret")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected = `{"access_token":"fakeToken","token_type":"bearer","expires_in":3600,"scope":"resources:read resources:write"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestTokenHandlerSuspendedUser(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// Should return 400 if user suspended
+	handler := fakeHandler(nil)
+	rr := httptest.NewRecorder()
+	params := url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "suspended@email.com")
+	params.Set("client_secret", "correctpassword")
+	req, err := http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected := `{"error":"invalid_grant","error_description":"user is suspended"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if owner of api client suspended
+	handler = fakeHandler(&fakeHandlerOptions{
+		userServiceStatus: "suspended",
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "client_credentials")
+	params.Set("client_id", "client1")
+	params.Set("client_secret", "correctsecret")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_grant","error_description":"user is suspended"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if the token service rejects the refresh token of a
+	// suspended user
+	handler = fakeHandler(nil)
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", "suspendedrefreshtoken")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_grant","error_description":"user is suspended"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+
+	// Should return 400 if owner of refresh token suspended
+	handler = fakeHandler(&fakeHandlerOptions{
+		userServiceStatus: "suspended",
+	})
+	rr = httptest.NewRecorder()
+	params = url.Values{}
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", "correctrefreshtoken")
+	req, err = http.NewRequest("POST", "/token", strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected = `{"error":"invalid_grant","error_description":"user is suspended"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
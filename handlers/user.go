@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/gorilla/mux"
 
 	"github.com/moonkeat/chainstack/models"
+	"github.com/moonkeat/chainstack/responses"
+	"github.com/moonkeat/chainstack/services"
 )
 
 func CreateUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -37,7 +40,7 @@ func CreateUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	userData, err := env.UserService.CreateUser(user.Email, user.Password, user.Admin, user.Quota)
+	userData, err := env.UserService.CreateUser(user.Email, user.Password, user.Admin, user.Quota, user.TokenExpiresIn)
 	if err != nil {
 		switch err.(type) {
 		case models.UserValidationError:
@@ -54,12 +57,35 @@ func CreateUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// ListUsersHandler lists a page of the users matching the query parameters.
+// The next page is linked in the Link header, and its cursor is also in the
+// X-Next-Cursor header.
 func ListUsersHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
-	users, err := env.UserService.ListUsers()
+	options, err := models.NewUserListOptions(r.URL.Query())
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			ActualError: err,
+		}
+	}
+
+	users, next, err := env.UserService.ListUsers(*options)
 	if err != nil {
 		return err
 	}
 
+	if next != nil {
+		cursor := next.Encode()
+		query := r.URL.Query()
+		query.Set("cursor", cursor)
+		nextURL := url.URL{
+			Path:     r.URL.Path,
+			RawQuery: query.Encode(),
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.String()))
+		w.Header().Set("X-Next-Cursor", cursor)
+	}
+
 	env.Render.JSON(w, http.StatusOK, users)
 	return nil
 }
@@ -88,6 +114,79 @@ func GetUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// UpdateUserHandler applies a JSON Merge Patch to a user: members set a field,
+// null members remove the quota or the access token lifetime, and missing
+// members are left unchanged. Removing admin revokes the tokens of the user,
+// they were granted the users scopes.
+func UpdateUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("request body is nil"),
+		}
+	}
+
+	var patch map[string]json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("failed to parse request body as json, err: %s", err),
+		}
+	}
+	defer r.Body.Close()
+
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	update, err := models.NewUserUpdate(patch)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			ActualError: err,
+		}
+	}
+
+	userData, err := env.UserService.UpdateUser(*userID, *update)
+	if err != nil && err != sql.ErrNoRows {
+		switch err.(type) {
+		case models.UserValidationError:
+			return HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				ActualError: err,
+			}
+		case services.UserEmailExistsError:
+			return HandlerError{
+				StatusCode:  http.StatusConflict,
+				ActualError: err,
+			}
+		default:
+			return err
+		}
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	if update.Admin != nil && !*update.Admin {
+		err = env.TokenService.RevokeUserTokens(userData.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	env.Render.JSON(w, http.StatusOK, userData)
+	return nil
+}
+
 func UpdateUserQuotaHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
 	if r.Body == nil {
 		return HandlerError{
@@ -121,7 +220,96 @@ func UpdateUserQuotaHandler(env *Env, w http.ResponseWriter, r *http.Request) er
 		}
 	}
 
-	userData, err := env.UserService.UpdateUserQuota(*userID, user.Quota)
+	userData, err := env.UserService.UpdateUser(*userID, models.UserUpdate{
+		Quota:       user.Quota,
+		RemoveQuota: user.Quota == nil,
+	})
+	if err != nil && err != sql.ErrNoRows {
+		switch err.(type) {
+		case models.UserValidationError:
+			return HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				ActualError: err,
+			}
+		default:
+			return err
+		}
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	env.Render.JSON(w, http.StatusOK, userData)
+	return nil
+}
+
+func UpdateUserTokenExpiresInHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("request body is nil"),
+		}
+	}
+
+	var user models.User
+	err := json.NewDecoder(r.Body).Decode(&user)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("failed to parse request body as json, err: %s", err),
+		}
+	}
+	defer r.Body.Close()
+
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	userData, err := env.UserService.UpdateUser(*userID, models.UserUpdate{
+		TokenExpiresIn:       user.TokenExpiresIn,
+		RemoveTokenExpiresIn: user.TokenExpiresIn == nil,
+	})
+	if err != nil && err != sql.ErrNoRows {
+		switch err.(type) {
+		case models.UserValidationError:
+			return HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				ActualError: err,
+			}
+		default:
+			return err
+		}
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	env.Render.JSON(w, http.StatusOK, userData)
+	return nil
+}
+
+// UnlockUserHandler lifts the lockout of a user after failed logins. The
+// lockout of the ips the logins came from is left to expire.
+func UnlockUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	userData, err := env.UserService.UnlockUser(*userID)
 	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
@@ -136,6 +324,46 @@ func UpdateUserQuotaHandler(env *Env, w http.ResponseWriter, r *http.Request) er
 	return nil
 }
 
+// SuspendUserHandler blocks a user from getting tokens and using the ones
+// they have, without deleting their resources and tokens.
+func SuspendUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return updateUserStatus(env, w, r, models.UserStatusSuspended)
+}
+
+// ReactivateUserHandler lifts the suspension of a user, their tokens that did
+// not expire meanwhile are valid again.
+func ReactivateUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return updateUserStatus(env, w, r, models.UserStatusActive)
+}
+
+func updateUserStatus(env *Env, w http.ResponseWriter, r *http.Request, status string) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	userData, err := env.UserService.UpdateUser(*userID, models.UserUpdate{
+		Status: &status,
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("user not found"),
+		}
+	}
+
+	env.Render.JSON(w, http.StatusOK, userData)
+	return nil
+}
+
+// DeleteUserHandler soft deletes a user and revokes their tokens, the user
+// and their resources can be restored until purged.
 func DeleteUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
 	userID, err := getUserIDFromRequest(r)
 	if err != nil {
@@ -156,10 +384,197 @@ func DeleteUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	err = env.TokenService.RevokeUserTokens(*userID)
+	if err != nil {
+		return err
+	}
+
+	env.Render.Data(w, http.StatusNoContent, nil)
+	return nil
+}
+
+// RestoreUserHandler undoes the deletion of a user who was not purged yet.
+// Their tokens were revoked, they have to log in again.
+func RestoreUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("deleted user not found"),
+		}
+	}
+
+	userData, err := env.UserService.RestoreUser(*userID)
+	if err != nil && err != sql.ErrNoRows {
+		switch err.(type) {
+		case services.UserEmailExistsError:
+			return HandlerError{
+				StatusCode:  http.StatusConflict,
+				ActualError: err,
+			}
+		default:
+			return err
+		}
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusNotFound,
+			ActualError: fmt.Errorf("deleted user not found"),
+		}
+	}
+
+	env.Render.JSON(w, http.StatusOK, userData)
+	return nil
+}
+
+// GetCurrentUserHandler returns the authenticated user with their resource
+// usage, for users without the users scopes.
+func GetCurrentUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	profile, err := currentUserProfile(env, *userID)
+	if err != nil {
+		return err
+	}
+
+	env.Render.JSON(w, http.StatusOK, profile)
+	return nil
+}
+
+// UpdateCurrentUserHandler changes the email of the authenticated user. Other
+// fields, like the quota, are rejected rather than ignored.
+func UpdateCurrentUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("request body is nil"),
+		}
+	}
+
+	var updateRequest struct {
+		Email *string `json:"email"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&updateRequest)
+	if err != nil {
+		return HandlerError{
+			StatusCode:  400,
+			ActualError: fmt.Errorf("failed to parse request body as json, err: %s", err),
+		}
+	}
+	defer r.Body.Close()
+
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	if updateRequest.Email == nil {
+		profile, err := currentUserProfile(env, *userID)
+		if err != nil {
+			return err
+		}
+
+		env.Render.JSON(w, http.StatusOK, profile)
+		return nil
+	}
+
+	user, err := env.UserService.UpdateUser(*userID, models.UserUpdate{
+		Email: updateRequest.Email,
+	})
+	if err != nil && err != sql.ErrNoRows {
+		switch err.(type) {
+		case models.UserValidationError:
+			return HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				ActualError: err,
+			}
+		case services.UserEmailExistsError:
+			return HandlerError{
+				StatusCode:  http.StatusConflict,
+				ActualError: err,
+			}
+		default:
+			return err
+		}
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusForbidden,
+			ActualError: fmt.Errorf("access denied"),
+		}
+	}
+
+	profile, err := userProfile(env, user)
+	if err != nil {
+		return err
+	}
+
+	env.Render.JSON(w, http.StatusOK, profile)
+	return nil
+}
+
+// DeleteCurrentUserHandler deletes the account of the authenticated user and
+// revokes their tokens. Like DeleteUserHandler, it is a soft delete.
+func DeleteCurrentUserHandler(env *Env, w http.ResponseWriter, r *http.Request) error {
+	userID, err := getUserIDFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	err = env.UserService.DeleteUser(*userID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return HandlerError{
+			StatusCode:  http.StatusForbidden,
+			ActualError: fmt.Errorf("access denied"),
+		}
+	}
+
+	err = env.TokenService.RevokeUserTokens(*userID)
+	if err != nil {
+		return err
+	}
+
 	env.Render.Data(w, http.StatusNoContent, nil)
 	return nil
 }
 
+func currentUserProfile(env *Env, userID int) (*responses.UserProfile, error) {
+	user, err := env.UserService.GetUser(userID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == sql.ErrNoRows {
+		return nil, HandlerError{
+			StatusCode:  http.StatusForbidden,
+			ActualError: fmt.Errorf("access denied"),
+		}
+	}
+
+	return userProfile(env, user)
+}
+
+// userProfile returns the user with the number of resources counted against
+// their quota.
+func userProfile(env *Env, user *models.User) (*responses.UserProfile, error) {
+	resources, err := env.ResourceService.ListResources(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &responses.UserProfile{
+		User:  user,
+		Usage: len(resources),
+	}, nil
+}
+
 var ErrInvalidUserID = fmt.Errorf("failed to parse user id from request")
 
 func getUserIDFromRequest(r *http.Request) (*int, error) {
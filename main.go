@@ -1,18 +1,24 @@
 package main
 
 import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/unrolled/render"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/moonkeat/chainstack/handlers"
+	"github.com/moonkeat/chainstack/models"
 	"github.com/moonkeat/chainstack/services"
 )
 
@@ -33,25 +39,239 @@ func main() {
 		addr = ":8080"
 	}
 
+	issuer, err := newIssuer()
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to read issuer")
+	}
+	if issuer == "" {
+		log.Warn().Msgf("ISSUER is not set, GET /.well-known/oauth-authorization-server returns 404")
+	}
+
+	var tokenExpiresIn time.Duration
+	if os.Getenv("TOKEN_EXPIRES_IN") != "" {
+		seconds, err := strconv.Atoi(os.Getenv("TOKEN_EXPIRES_IN"))
+		if err != nil || seconds <= 0 || seconds > int(models.TokenMaxExpiresIn.Seconds()) {
+			log.Fatal().Msgf("TOKEN_EXPIRES_IN should be between 1 and %d seconds, got: '%s'", int(models.TokenMaxExpiresIn.Seconds()), os.Getenv("TOKEN_EXPIRES_IN"))
+		}
+		tokenExpiresIn = time.Duration(seconds) * time.Second
+	}
+
+	passwordPolicy, err := newPasswordPolicy()
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to create password policy")
+	}
+
+	passwordHasher, err := newPasswordHasher()
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to create password hasher")
+	}
+
+	userService := services.NewUserService(db, services.LoginLockout{
+		MaxAttempts:   positiveIntEnv("LOGIN_MAX_ATTEMPTS", services.DefaultLoginLockout.MaxAttempts),
+		IPMaxAttempts: positiveIntEnv("LOGIN_IP_MAX_ATTEMPTS", services.DefaultLoginLockout.IPMaxAttempts),
+		Duration:      time.Duration(positiveIntEnv("LOGIN_LOCKOUT_DURATION", int(services.DefaultLoginLockout.Duration.Seconds()))) * time.Second,
+	}, passwordPolicy, passwordHasher)
+
+	var mfaEncryptionKey []byte
+	if os.Getenv("MFA_ENCRYPTION_KEY") != "" {
+		mfaEncryptionKey, err = base64.StdEncoding.DecodeString(os.Getenv("MFA_ENCRYPTION_KEY"))
+		if err != nil || len(mfaEncryptionKey) != services.MFAEncryptionKeySize {
+			log.Fatal().Msgf("MFA_ENCRYPTION_KEY should be %d base64 encoded bytes", services.MFAEncryptionKeySize)
+		}
+	}
+
+	requireAdminMFA, _ := strconv.ParseBool(os.Getenv("REQUIRE_ADMIN_MFA"))
+	if requireAdminMFA && mfaEncryptionKey == nil {
+		log.Fatal().Msgf("REQUIRE_ADMIN_MFA needs MFA_ENCRYPTION_KEY")
+	}
+
+	tokenService, err := newTokenService(db)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to create token service")
+	}
+
+	resourceService := services.NewResourceService(db)
+	softDeleteRetention := time.Duration(positiveIntEnv("SOFT_DELETE_RETENTION", int(services.DefaultSoftDeleteRetention.Seconds()))) * time.Second
+
 	go func() {
 		for {
-			err := services.NewTokenService(db).CleanExpiredTokens()
+			err := tokenService.CleanExpiredTokens()
 			if err != nil {
 				log.Error().Err(err).Msgf("Failed to clean expired tokens")
 			}
+			err = userService.CleanExpiredLoginAttempts()
+			if err != nil {
+				log.Error().Err(err).Msgf("Failed to clean expired login attempts")
+			}
+			err = userService.CleanExpiredPasswordResetTokens()
+			if err != nil {
+				log.Error().Err(err).Msgf("Failed to clean expired password reset tokens")
+			}
+			err = userService.PurgeDeletedUsers(softDeleteRetention)
+			if err != nil {
+				log.Error().Err(err).Msgf("Failed to purge deleted users")
+			}
+			err = resourceService.PurgeDeletedResources(softDeleteRetention)
+			if err != nil {
+				log.Error().Err(err).Msgf("Failed to purge deleted resources")
+			}
 			time.Sleep(1 * time.Hour)
 		}
 	}()
 
 	log.Info().Msgf("Server is running and listen on %s", addr)
 	err = http.ListenAndServe(addr, handlers.NewHandler(&handlers.Env{
-		Render:          render.New(),
-		UserService:     services.NewUserService(db),
-		TokenService:    services.NewTokenService(db),
-		ResourceService: services.NewResourceService(db),
+		Render:           handlers.NewRender(),
+		UserService:      userService,
+		TokenService:     tokenService,
+		ResourceService:  resourceService,
+		APIClientService: services.NewAPIClientService(db),
+		MFAService:       services.NewMFAService(db, mfaEncryptionKey, services.DefaultMFAIssuer),
+
+		IntrospectionClientID:     os.Getenv("INTROSPECTION_CLIENT_ID"),
+		IntrospectionClientSecret: os.Getenv("INTROSPECTION_CLIENT_SECRET"),
+		Issuer:                    issuer,
+
+		TokenExpiresIn:  tokenExpiresIn,
+		RequireAdminMFA: requireAdminMFA,
 	}))
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatal().Err(err).Msgf("Server could not listen on %s", addr)
 	}
 	log.Info().Msgf("Server stopped")
 }
+
+// positiveIntEnv returns the environment variable as a positive number, or
+// defaultValue if it is not set.
+func positiveIntEnv(name string, defaultValue int) int {
+	if os.Getenv(name) == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		log.Fatal().Msgf("%s should be a positive number, got: '%s'", name, os.Getenv(name))
+	}
+
+	return value
+}
+
+// newIssuer returns the base url of ISSUER without its trailing slash, or
+// an empty string if ISSUER is not set. The discovery document publishes it,
+// it can not be taken from the Host and X-Forwarded-* headers of the request
+// which anyone can set.
+func newIssuer() (string, error) {
+	if os.Getenv("ISSUER") == "" {
+		return "", nil
+	}
+
+	issuer, err := url.Parse(os.Getenv("ISSUER"))
+	if err != nil || (issuer.Scheme != "http" && issuer.Scheme != "https") || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+		return "", fmt.Errorf("ISSUER should be an http or https url without query or fragment, got: '%s'", os.Getenv("ISSUER"))
+	}
+
+	return strings.TrimSuffix(issuer.String(), "/"), nil
+}
+
+// newPasswordPolicy returns the password rules of the PASSWORD_* variables,
+// combined with the breached passwords of BREACHED_PASSWORDS_FILE if set, a
+// file or a directory of ranges.
+func newPasswordPolicy() (models.PasswordPolicy, error) {
+	rules := models.PasswordRules{
+		MinLength:        positiveIntEnv("PASSWORD_MIN_LENGTH", models.DefaultPasswordRules.MinLength),
+		MaxLength:        positiveIntEnv("PASSWORD_MAX_LENGTH", models.DefaultPasswordRules.MaxLength),
+		CharacterClasses: positiveIntEnv("PASSWORD_CHARACTER_CLASSES", models.DefaultPasswordRules.CharacterClasses),
+		DisallowEmail:    models.DefaultPasswordRules.DisallowEmail,
+	}
+	if rules.MaxLength > models.PasswordMaxLength {
+		return nil, fmt.Errorf("PASSWORD_MAX_LENGTH should be at most %d, bcrypt ignores the rest of longer passwords", models.PasswordMaxLength)
+	}
+	if rules.MinLength > rules.MaxLength {
+		return nil, fmt.Errorf("PASSWORD_MIN_LENGTH should be at most PASSWORD_MAX_LENGTH")
+	}
+	if rules.CharacterClasses > 4 {
+		return nil, fmt.Errorf("PASSWORD_CHARACTER_CLASSES should be at most 4")
+	}
+
+	if os.Getenv("BREACHED_PASSWORDS_FILE") == "" {
+		return rules, nil
+	}
+
+	breachedPasswords, err := services.NewBreachedPasswordFile(os.Getenv("BREACHED_PASSWORDS_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	return models.PasswordPolicies{rules, breachedPasswords}, nil
+}
+
+// newPasswordHasher returns the password hasher selected by PASSWORD_HASHER,
+// either bcrypt (default) or argon2id.
+func newPasswordHasher() (services.PasswordHasher, error) {
+	switch os.Getenv("PASSWORD_HASHER") {
+	case "", services.PasswordHasherBcrypt:
+		cost := positiveIntEnv("BCRYPT_COST", services.DefaultBcryptHasher.Cost)
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("BCRYPT_COST should be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+
+		return services.BcryptHasher{Cost: cost}, nil
+	case services.PasswordHasherArgon2id:
+		parallelism := positiveIntEnv("ARGON2_PARALLELISM", int(services.DefaultArgon2idHasher.Parallelism))
+		if parallelism > 255 {
+			return nil, fmt.Errorf("ARGON2_PARALLELISM should be at most 255")
+		}
+
+		return services.Argon2idHasher{
+			Memory:      uint32(positiveIntEnv("ARGON2_MEMORY", int(services.DefaultArgon2idHasher.Memory))),
+			Iterations:  uint32(positiveIntEnv("ARGON2_ITERATIONS", int(services.DefaultArgon2idHasher.Iterations))),
+			Parallelism: uint8(parallelism),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported password hasher: '%s'", os.Getenv("PASSWORD_HASHER"))
+	}
+}
+
+// newTokenService returns the token service selected by TOKEN_FORMAT, either
+// opaque tokens stored in postgres (default) or signed JWTs.
+func newTokenService(db *sqlx.DB) (services.TokenService, error) {
+	switch os.Getenv("TOKEN_FORMAT") {
+	case "", "opaque":
+		return services.NewTokenService(db), nil
+	case "jwt":
+		if os.Getenv("JWT_KEY_STORE") == "database" {
+			encryptionKey, err := base64.StdEncoding.DecodeString(os.Getenv("SIGNING_KEY_ENCRYPTION_KEY"))
+			if err != nil || len(encryptionKey) != services.SigningKeyEncryptionKeySize {
+				return nil, fmt.Errorf("JWT_KEY_STORE=database needs SIGNING_KEY_ENCRYPTION_KEY, %d base64 encoded bytes", services.SigningKeyEncryptionKeySize)
+			}
+
+			keyStore := services.NewSigningKeyStore(services.NewSigningKeyService(db, encryptionKey), encryptionKey)
+			return services.NewJWTTokenService(db, keyStore, services.NewJWTStatusStore(db)), nil
+		}
+
+		algorithm := os.Getenv("JWT_ALGORITHM")
+		if algorithm == "" {
+			algorithm = services.JWTAlgorithmHS256
+		}
+
+		var keyData []byte
+		if algorithm == services.JWTAlgorithmHS256 {
+			keyData = []byte(os.Getenv("JWT_SECRET"))
+		} else {
+			var err error
+			keyData, err = ioutil.ReadFile(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		key, err := services.NewJWTKey(os.Getenv("JWT_KEY_ID"), algorithm, keyData)
+		if err != nil {
+			return nil, err
+		}
+
+		return services.NewJWTTokenService(db, services.NewStaticJWTKeyStore(key), services.NewJWTStatusStore(db)), nil
+	default:
+		return nil, fmt.Errorf("unsupported token format: '%s'", os.Getenv("TOKEN_FORMAT"))
+	}
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const APIClientMaxRedirectURIs = 10
+
+type APIClientValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e APIClientValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// APIClient is a named client credential owned by a user. Secret only holds
+// the plain secret right after creation, it is stored as a bcrypt hash. An
+// empty Scope means every scope the user can be granted. TokenExpiresIn
+// overrides the access token lifetime in seconds. Clients with RedirectURIs
+// can sign in any user with the authorization code grant. Public clients, for
+// browser and mobile apps, have no secret and only use that grant.
+type APIClient struct {
+	ID             int            `db:"id" json:"-"`
+	ClientID       string         `db:"client_id" json:"client_id"`
+	Secret         string         `db:"secret" json:"client_secret,omitempty"`
+	Name           string         `db:"name" json:"name"`
+	Scope          string         `db:"scope" json:"scope,omitempty"`
+	TokenExpiresIn *int           `db:"token_expires_in" json:"token_expires_in,omitempty"`
+	RedirectURIs   pq.StringArray `db:"redirect_uris" json:"redirect_uris,omitempty"`
+	Public         bool           `db:"public" json:"public,omitempty"`
+	CreatedAt      time.Time      `db:"created_at" json:"created_at"`
+	UserID         int            `db:"user_id" json:"-"`
+}
+
+func ValidateAPIClient(name string, tokenExpiresIn *int, redirectURIs []string, public bool) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return APIClientValidationError{
+			Field:  "name",
+			Reason: fmt.Sprintf("name is required"),
+		}
+	}
+
+	if len(name) > 100 {
+		return APIClientValidationError{
+			Field:  "name",
+			Reason: fmt.Sprintf("name should be at most 100 characters"),
+		}
+	}
+
+	if tokenExpiresIn != nil && (*tokenExpiresIn <= 0 || *tokenExpiresIn > int(TokenMaxExpiresIn.Seconds())) {
+		return APIClientValidationError{
+			Field:  "token_expires_in",
+			Reason: fmt.Sprintf("token_expires_in should be between 1 and %d seconds", int(TokenMaxExpiresIn.Seconds())),
+		}
+	}
+
+	if len(redirectURIs) > APIClientMaxRedirectURIs {
+		return APIClientValidationError{
+			Field:  "redirect_uris",
+			Reason: fmt.Sprintf("redirect_uris should have at most %d uris", APIClientMaxRedirectURIs),
+		}
+	}
+
+	for _, redirectURI := range redirectURIs {
+		if !validRedirectURI(redirectURI) {
+			return APIClientValidationError{
+				Field:  "redirect_uris",
+				Reason: fmt.Sprintf("'%s' is not a valid redirect uri", redirectURI),
+			}
+		}
+	}
+
+	if public && len(redirectURIs) == 0 {
+		return APIClientValidationError{
+			Field:  "redirect_uris",
+			Reason: fmt.Sprintf("redirect_uris are required for public clients"),
+		}
+	}
+
+	return nil
+}
+
+// validRedirectURI accepts absolute uris without fragment. Plain http is only
+// accepted for loopback addresses, custom schemes are accepted for native
+// apps with a host or a path. Schemes that run or embed content in the
+// browser instead of leaving it are rejected.
+func validRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || u.Opaque != "" {
+		return false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	case "javascript", "data", "vbscript", "file", "blob", "about":
+		return false
+	default:
+		return u.Host != "" || strings.Trim(u.Path, "/") != ""
+	}
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// CodeChallengeMethodS256 is the only PKCE code challenge method accepted,
+// the code challenge is the unpadded base64url SHA-256 of the code verifier.
+const CodeChallengeMethodS256 = "S256"
+
+// AuthorizationCode is a single-use code issued by the authorization
+// endpoint, exchanged for tokens by the client that requested it.
+type AuthorizationCode struct {
+	Code          string    `db:"code"`
+	ClientID      string    `db:"client_id"`
+	RedirectURI   string    `db:"redirect_uri"`
+	Scope         string    `db:"scope"`
+	CodeChallenge string    `db:"code_challenge"`
+	Expires       time.Time `db:"expires"`
+	UserID        int       `db:"user_id"`
+}
@@ -0,0 +1,19 @@
+package models
+
+const (
+	// MFARecoveryCodes is the number of recovery codes a user gets when
+	// enabling two-factor authentication.
+	MFARecoveryCodes = 10
+)
+
+// MFA is the two-factor authentication state of a user.
+type MFA struct {
+	Enabled                bool `db:"confirmed" json:"enabled"`
+	RecoveryCodesRemaining int  `db:"recovery_codes_remaining" json:"recovery_codes_remaining"`
+}
+
+// MFAEnrollment is a TOTP secret waiting for the user to confirm a code of it.
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordMaxLength is the number of bytes bcrypt hashes, longer passwords
+// would be silently truncated.
+const PasswordMaxLength = 72
+
+// PasswordPolicy decides which passwords users can choose. Rejected passwords
+// return a UserValidationError, other errors mean the password could not be
+// checked.
+type PasswordPolicy interface {
+	ValidatePassword(email string, password string) error
+}
+
+// PasswordPolicies combines password policies, a password has to pass all of
+// them.
+type PasswordPolicies []PasswordPolicy
+
+func (p PasswordPolicies) ValidatePassword(email string, password string) error {
+	for _, policy := range p {
+		err := policy.ValidatePassword(email, password)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PasswordRules are the composition rules of passwords. MinLength counts
+// characters and MaxLength counts bytes. CharacterClasses is how many of
+// lowercase letters, uppercase letters, digits and symbols a password needs.
+type PasswordRules struct {
+	MinLength        int
+	MaxLength        int
+	CharacterClasses int
+	DisallowEmail    bool
+}
+
+var DefaultPasswordRules = PasswordRules{
+	MinLength:     8,
+	MaxLength:     PasswordMaxLength,
+	DisallowEmail: true,
+}
+
+func (r PasswordRules) ValidatePassword(email string, password string) error {
+	if utf8.RuneCountInString(password) < r.MinLength {
+		return UserValidationError{
+			Field:  "password",
+			Reason: fmt.Sprintf("password should be at least %d characters", r.MinLength),
+		}
+	}
+
+	if r.MaxLength > 0 && len(password) > r.MaxLength {
+		return UserValidationError{
+			Field:  "password",
+			Reason: fmt.Sprintf("password should be at most %d bytes", r.MaxLength),
+		}
+	}
+
+	if passwordCharacterClasses(password) < r.CharacterClasses {
+		return UserValidationError{
+			Field:  "password",
+			Reason: fmt.Sprintf("password should contain at least %d of lowercase letters, uppercase letters, digits and symbols", r.CharacterClasses),
+		}
+	}
+
+	if r.DisallowEmail && strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(email)) {
+		return UserValidationError{
+			Field:  "password",
+			Reason: fmt.Sprintf("password should not be the email"),
+		}
+	}
+
+	return nil
+}
+
+// passwordCharacterClasses counts the classes of characters in the password,
+// anything but letters and digits is a symbol.
+func passwordCharacterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = 1
+		case unicode.IsUpper(c):
+			upper = 1
+		case unicode.IsDigit(c):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	return lower + upper + digit + symbol
+}
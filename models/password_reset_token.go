@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// PasswordResetTokenExpiresIn is how long an admin-issued password reset token
+// can be used.
+const PasswordResetTokenExpiresIn = 24 * time.Hour
+
+// PasswordResetToken lets a user set a new password without the current one.
+// Token is only set when the token is issued.
+type PasswordResetToken struct {
+	Token   string    `db:"token" json:"token"`
+	UserID  int       `db:"user_id" json:"user_id"`
+	Expires time.Time `db:"expires" json:"expires"`
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ScopeResourcesRead  = "resources:read"
+	ScopeResourcesWrite = "resources:write"
+	ScopeUsersRead      = "users:read"
+	ScopeUsersWrite     = "users:write"
+	ScopeClientsRead    = "clients:read"
+	ScopeClientsWrite   = "clients:write"
+	ScopeTokensRead     = "tokens:read"
+	ScopeTokensWrite    = "tokens:write"
+)
+
+// Scopes lists every scope that can be granted.
+var Scopes = Scope{
+	ScopeResourcesRead, ScopeResourcesWrite,
+	ScopeUsersRead, ScopeUsersWrite,
+	ScopeClientsRead, ScopeClientsWrite,
+	ScopeTokensRead, ScopeTokensWrite,
+}
+
+// legacyScopes maps the scopes issued before fine-grained scopes existed to
+// the scopes they used to grant, so tokens issued earlier keep working.
+var legacyScopes = map[string][]string{
+	"resources": {ScopeResourcesRead, ScopeResourcesWrite},
+	"users":     {ScopeUsersRead, ScopeUsersWrite},
+}
+
+type ScopeValidationError struct {
+	Scope string
+}
+
+func (e ScopeValidationError) Error() string {
+	return fmt.Sprintf("invalid scope: '%s'", e.Scope)
+}
+
+// Scope is a set of scopes, kept in the order they were first seen.
+type Scope []string
+
+// ParseScope parses a space-delimited scope string as defined in RFC 6749,
+// expanding legacy scopes and dropping duplicates.
+func ParseScope(scope string) Scope {
+	parsed := Scope{}
+	for _, s := range strings.Fields(scope) {
+		expanded, ok := legacyScopes[s]
+		if !ok {
+			expanded = []string{s}
+		}
+
+		for _, e := range expanded {
+			if !parsed.Contains(e) {
+				parsed = append(parsed, e)
+			}
+		}
+	}
+
+	return parsed
+}
+
+func (s Scope) Contains(scope string) bool {
+	for _, c := range s {
+		if c == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Narrow returns the requested scope if every scope in it is part of s. An
+// empty request returns s unchanged.
+func (s Scope) Narrow(requested Scope) (Scope, error) {
+	if len(requested) == 0 {
+		return s, nil
+	}
+
+	for _, r := range requested {
+		if !s.Contains(r) {
+			return nil, ScopeValidationError{Scope: r}
+		}
+	}
+
+	return requested, nil
+}
+
+// Intersect returns the scopes of s that are also in other.
+func (s Scope) Intersect(other Scope) Scope {
+	common := Scope{}
+	for _, c := range s {
+		if other.Contains(c) {
+			common = append(common, c)
+		}
+	}
+
+	return common
+}
+
+// Without returns s minus the given scopes.
+func (s Scope) Without(scopes ...string) Scope {
+	remaining := Scope{}
+	for _, c := range s {
+		if !Scope(scopes).Contains(c) {
+			remaining = append(remaining, c)
+		}
+	}
+
+	return remaining
+}
+
+func (s Scope) String() string {
+	return strings.Join(s, " ")
+}
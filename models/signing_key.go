@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+const (
+	// SigningKeyStatusActive keys sign new tokens and verify existing ones.
+	SigningKeyStatusActive = "active"
+	// SigningKeyStatusVerifyOnly keys were rotated out but still verify
+	// tokens signed before the rotation.
+	SigningKeyStatusVerifyOnly = "verify_only"
+	// SigningKeyStatusRetired keys are kept for audit only.
+	SigningKeyStatusRetired = "retired"
+)
+
+type SigningKey struct {
+	ID         int        `db:"id"`
+	KeyID      string     `db:"kid"`
+	Algorithm  string     `db:"algorithm"`
+	PrivateKey string     `db:"private_key"`
+	Status     string     `db:"status"`
+	CreatedAt  time.Time  `db:"created_at"`
+	RotatedAt  *time.Time `db:"rotated_at"`
+}
+
+// JSONWebKey is the public part of a signing key as published in a JWK Set
+// (RFC 7517).
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
@@ -1,10 +1,81 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// TokenMaxExpiresIn bounds the access token lifetime that can be
+	// configured for a user or an API client.
+	TokenMaxExpiresIn               = 24 * time.Hour
+	PersonalAccessTokenMaxExpiresIn = 365 * 24 * time.Hour
+)
+
+type TokenValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e TokenValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
 
 type Token struct {
+	ID         int        `db:"id"`
+	Token      string     `db:"token"`
+	Expires    time.Time  `db:"expires"`
+	Scope      string     `db:"scope"`
+	UserID     int        `db:"user_id"`
+	CreatedAt  time.Time  `db:"created_at"`
+	Name       *string    `db:"name"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+}
+
+// PersonalAccessToken is a named, long-lived access token minted by a user.
+// Token only holds the plain token right after creation.
+type PersonalAccessToken struct {
+	ID         int        `db:"id" json:"id"`
+	Name       string     `db:"name" json:"name"`
+	Token      string     `db:"-" json:"token,omitempty"`
+	Scope      string     `db:"scope" json:"scope"`
+	Expires    time.Time  `db:"expires" json:"expires"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+}
+
+func ValidatePersonalAccessToken(name string, expiresIn time.Duration) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return TokenValidationError{
+			Field:  "name",
+			Reason: fmt.Sprintf("name is required"),
+		}
+	}
+
+	if len(name) > 100 {
+		return TokenValidationError{
+			Field:  "name",
+			Reason: fmt.Sprintf("name should be at most 100 characters"),
+		}
+	}
+
+	if expiresIn <= 0 || expiresIn > PersonalAccessTokenMaxExpiresIn {
+		return TokenValidationError{
+			Field:  "expires_in",
+			Reason: fmt.Sprintf("expires_in should be between 1 and %d seconds", int(PersonalAccessTokenMaxExpiresIn.Seconds())),
+		}
+	}
+
+	return nil
+}
+
+type RefreshToken struct {
 	Token   string    `db:"token"`
+	Family  string    `db:"family"`
 	Expires time.Time `db:"expires"`
 	Scope   string    `db:"scope"`
+	Used    bool      `db:"used"`
 	UserID  int       `db:"user_id"`
 }
@@ -1,7 +1,11 @@
 package models
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -15,15 +19,56 @@ func (e UserValidationError) Error() string {
 	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
 }
 
+const (
+	// UserStatusActive users sign in and use their tokens.
+	UserStatusActive = "active"
+	// UserStatusSuspended users can not get tokens and their tokens are
+	// rejected, their resources and tokens are kept for reactivation.
+	UserStatusSuspended = "suspended"
+)
+
+// User is an account of the system. TokenExpiresIn overrides the access token
+// lifetime in seconds. LockedUntil is only set while failed logins lock the
+// user out. MFAEnabled tells whether password logins need a one-time code.
+// Status is one of the UserStatus constants.
 type User struct {
-	ID       int    `db:"id" json:"id"`
-	Email    string `db:"email" json:"email"`
-	Password string `db:"password" json:"password,omitempty"`
-	Admin    bool   `db:"admin" json:"admin"`
-	Quota    *int   `db:"quota" json:"quota,omitempty"`
+	ID                  int        `db:"id" json:"id"`
+	Email               string     `db:"email" json:"email"`
+	Password            string     `db:"password" json:"password,omitempty"`
+	Admin               bool       `db:"admin" json:"admin"`
+	Quota               *int       `db:"quota" json:"quota,omitempty"`
+	TokenExpiresIn      *int       `db:"token_expires_in" json:"token_expires_in,omitempty"`
+	FailedLoginAttempts int        `db:"failed_login_attempts" json:"failed_login_attempts,omitempty"`
+	LockedUntil         *time.Time `db:"locked_until" json:"locked_until,omitempty"`
+	MFAEnabled          bool       `db:"mfa_enabled" json:"mfa_enabled,omitempty"`
+	Status              string     `db:"status" json:"status,omitempty"`
+	CreatedAt           *time.Time `db:"created_at" json:"created_at,omitempty"`
+}
+
+// ValidateUser validates the fields of a user, when created and after every
+// update. Passwords are validated apart, against the password policy.
+func ValidateUser(user *User) error {
+	err := ValidateUserEmail(user.Email)
+	if err != nil {
+		return err
+	}
+
+	if user.Quota != nil && *user.Quota < 0 {
+		return UserValidationError{
+			Field:  "quota",
+			Reason: fmt.Sprintf("quota should be at least 0"),
+		}
+	}
+
+	err = ValidateUserTokenExpiresIn(user.TokenExpiresIn)
+	if err != nil {
+		return err
+	}
+
+	return ValidateUserStatus(user.Status)
 }
 
-func ValidateUser(email string, password string) error {
+func ValidateUserEmail(email string) error {
 	if !govalidator.IsEmail(email) {
 		return UserValidationError{
 			Field:  "email",
@@ -31,14 +76,128 @@ func ValidateUser(email string, password string) error {
 		}
 	}
 
-	if len(password) < 8 {
+	return nil
+}
+
+func ValidateUserTokenExpiresIn(tokenExpiresIn *int) error {
+	if tokenExpiresIn != nil && (*tokenExpiresIn <= 0 || *tokenExpiresIn > int(TokenMaxExpiresIn.Seconds())) {
+		return UserValidationError{
+			Field:  "token_expires_in",
+			Reason: fmt.Sprintf("token_expires_in should be between 1 and %d seconds", int(TokenMaxExpiresIn.Seconds())),
+		}
+	}
+
+	return nil
+}
+
+func ValidateUserStatus(status string) error {
+	if status != UserStatusActive && status != UserStatusSuspended {
 		return UserValidationError{
-			Field:  "password",
-			Reason: fmt.Sprintf("password should be at least 8 characters"),
+			Field:  "status",
+			Reason: fmt.Sprintf("status should be %s or %s", UserStatusActive, UserStatusSuspended),
 		}
 	}
 
 	return nil
 }
 
+// UserUpdate is a JSON Merge Patch (RFC 7396) of a user. Fields missing from
+// the patch are nil and left unchanged. Quota and TokenExpiresIn can be null
+// too, which removes them: the user has no quota and the default access token
+// lifetime again.
+type UserUpdate struct {
+	Email                *string
+	Admin                *bool
+	Quota                *int
+	RemoveQuota          bool
+	TokenExpiresIn       *int
+	RemoveTokenExpiresIn bool
+	Status               *string
+}
+
+// NewUserUpdate reads the members of a JSON Merge Patch of a user. Members
+// that can not be patched, such as the password, are rejected rather than
+// ignored.
+func NewUserUpdate(patch map[string]json.RawMessage) (*UserUpdate, error) {
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	update := UserUpdate{}
+	for _, field := range fields {
+		value := patch[field]
+		isNull := bytes.Equal(bytes.TrimSpace(value), []byte("null"))
+
+		var err error
+		switch field {
+		case "email":
+			if isNull {
+				return nil, userUpdateNullError(field)
+			}
+			err = json.Unmarshal(value, &update.Email)
+		case "admin":
+			if isNull {
+				return nil, userUpdateNullError(field)
+			}
+			err = json.Unmarshal(value, &update.Admin)
+		case "status":
+			if isNull {
+				return nil, userUpdateNullError(field)
+			}
+			err = json.Unmarshal(value, &update.Status)
+		case "quota":
+			update.RemoveQuota = isNull
+			err = json.Unmarshal(value, &update.Quota)
+		case "token_expires_in":
+			update.RemoveTokenExpiresIn = isNull
+			err = json.Unmarshal(value, &update.TokenExpiresIn)
+		default:
+			return nil, UserValidationError{
+				Field:  field,
+				Reason: fmt.Sprintf("%s can not be updated", field),
+			}
+		}
+		if err != nil {
+			return nil, UserValidationError{
+				Field:  field,
+				Reason: fmt.Sprintf("%s has the wrong type", field),
+			}
+		}
+	}
+
+	return &update, nil
+}
+
+func userUpdateNullError(field string) error {
+	return UserValidationError{
+		Field:  field,
+		Reason: fmt.Sprintf("%s can not be null", field),
+	}
+}
+
+// Apply sets the fields of the update on the user.
+func (u UserUpdate) Apply(user *User) {
+	if u.Email != nil {
+		user.Email = *u.Email
+	}
+
+	if u.Admin != nil {
+		user.Admin = *u.Admin
+	}
+
+	if u.Quota != nil || u.RemoveQuota {
+		user.Quota = u.Quota
+	}
+
+	if u.TokenExpiresIn != nil || u.RemoveTokenExpiresIn {
+		user.TokenExpiresIn = u.TokenExpiresIn
+	}
+
+	if u.Status != nil {
+		user.Status = *u.Status
+	}
+}
+
 // TODO: test admin create user , non admin create user, test update quota < resources
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	UserSortID        = "id"
+	UserSortEmail     = "email"
+	UserSortCreatedAt = "created_at"
+)
+
+const (
+	UserListDefaultLimit = 20
+	UserListMaxLimit     = 100
+)
+
+// UserListOptions filter, sort and paginate a list of users. Sort is one of
+// the UserSort constants. Pages are at most Limit users long, and start after
+// the user the Cursor points to, or at the first user without a cursor. A
+// Limit of 0 lists every user in a single page. MinQuota and MaxQuota never
+// match users without a quota.
+type UserListOptions struct {
+	Status      string
+	Admin       *bool
+	EmailPrefix string
+	MinQuota    *int
+	MaxQuota    *int
+	Sort        string
+	Descending  bool
+	Limit       int
+	Cursor      *UserCursor
+}
+
+// UserCursor is the position of a user in a sorted list of users: the sort
+// key of the user, and the id to break ties. It is handed to clients encoded,
+// who should not rely on its content.
+type UserCursor struct {
+	Sort       string     `json:"sort"`
+	Descending bool       `json:"desc,omitempty"`
+	ID         int        `json:"id"`
+	Email      string     `json:"email,omitempty"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+}
+
+// NewUserCursor returns the cursor of the user in the sort order of the
+// options, to list the users after them.
+func NewUserCursor(options UserListOptions, user User) UserCursor {
+	cursor := UserCursor{
+		Sort:       options.Sort,
+		Descending: options.Descending,
+		ID:         user.ID,
+	}
+	switch options.Sort {
+	case UserSortEmail:
+		cursor.Email = strings.ToLower(user.Email)
+	case UserSortCreatedAt:
+		cursor.CreatedAt = user.CreatedAt
+	}
+
+	return cursor
+}
+
+func (c UserCursor) Encode() string {
+	cursor, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(cursor)
+}
+
+func DecodeUserCursor(encoded string) (*UserCursor, error) {
+	invalidCursorError := UserValidationError{
+		Field:  "cursor",
+		Reason: fmt.Sprintf("cursor is invalid"),
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, invalidCursorError
+	}
+
+	cursor := UserCursor{}
+	err = json.Unmarshal(decoded, &cursor)
+	if err != nil {
+		return nil, invalidCursorError
+	}
+
+	if cursor.Sort == UserSortCreatedAt && cursor.CreatedAt == nil {
+		return nil, invalidCursorError
+	}
+
+	return &cursor, nil
+}
+
+// NewUserListOptions reads the query parameters of a request listing users.
+// Sort is a field, prefixed with "-" to sort in descending order. A cursor
+// only continues the list it was returned for, so it has to come with the
+// same sort. The users are only paginated when the query has a limit or a
+// cursor, so that clients from before pagination still get every user.
+func NewUserListOptions(query url.Values) (*UserListOptions, error) {
+	options := UserListOptions{
+		Status:      query.Get("status"),
+		EmailPrefix: query.Get("email_prefix"),
+		Sort:        UserSortID,
+	}
+
+	if options.Status != "" {
+		err := ValidateUserStatus(options.Status)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if admin := query.Get("admin"); admin != "" {
+		value, err := strconv.ParseBool(admin)
+		if err != nil {
+			return nil, UserValidationError{
+				Field:  "admin",
+				Reason: fmt.Sprintf("admin should be true or false"),
+			}
+		}
+		options.Admin = &value
+	}
+
+	for _, field := range []string{"min_quota", "max_quota"} {
+		if quota := query.Get(field); quota != "" {
+			value, err := strconv.Atoi(quota)
+			if err != nil || value < 0 {
+				return nil, UserValidationError{
+					Field:  field,
+					Reason: fmt.Sprintf("%s should be a number of at least 0", field),
+				}
+			}
+			if field == "min_quota" {
+				options.MinQuota = &value
+			} else {
+				options.MaxQuota = &value
+			}
+		}
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		options.Descending = strings.HasPrefix(sort, "-")
+		options.Sort = strings.TrimPrefix(sort, "-")
+		if options.Sort != UserSortID && options.Sort != UserSortEmail && options.Sort != UserSortCreatedAt {
+			return nil, UserValidationError{
+				Field:  "sort",
+				Reason: fmt.Sprintf("sort should be %s, %s or %s, prefixed with - for descending order", UserSortID, UserSortEmail, UserSortCreatedAt),
+			}
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 || value > UserListMaxLimit {
+			return nil, UserValidationError{
+				Field:  "limit",
+				Reason: fmt.Sprintf("limit should be between 1 and %d", UserListMaxLimit),
+			}
+		}
+		options.Limit = value
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		var err error
+		options.Cursor, err = DecodeUserCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		if options.Cursor.Sort != options.Sort || options.Cursor.Descending != options.Descending {
+			return nil, UserValidationError{
+				Field:  "cursor",
+				Reason: fmt.Sprintf("cursor was returned for another sort"),
+			}
+		}
+
+		if options.Limit == 0 {
+			options.Limit = UserListDefaultLimit
+		}
+	}
+
+	return &options, nil
+}
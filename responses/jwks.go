@@ -0,0 +1,7 @@
+package responses
+
+import "github.com/moonkeat/chainstack/models"
+
+type JSONWebKeySet struct {
+	Keys []models.JSONWebKey `json:"keys"`
+}
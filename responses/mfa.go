@@ -0,0 +1,5 @@
+package responses
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
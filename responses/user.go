@@ -0,0 +1,10 @@
+package responses
+
+import "github.com/moonkeat/chainstack/models"
+
+// UserProfile is the authenticated user with the number of resources counted
+// against their quota.
+type UserProfile struct {
+	*models.User
+	Usage int `json:"usage"`
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 
+	"github.com/moonkeat/chainstack/models"
 	"github.com/moonkeat/chainstack/services"
 )
 
@@ -16,6 +17,7 @@ func main() {
 	passwordPtr := flag.String("password", "", "user password")
 	isAdminPtr := flag.Bool("admin", false, "add admin user")
 	quotaPtr := flag.Int("quota", services.UserQuotaUndefined, "user quota")
+	tokenExpiresInPtr := flag.Int("token-expires-in", 0, "access token lifetime of the user in seconds, server default if 0")
 
 	flag.Parse()
 
@@ -33,9 +35,14 @@ func main() {
 		log.Fatalf("User quota should be -1 (unlimited quota) or at least 0")
 	}
 
-	userService := services.NewUserService(db)
+	var tokenExpiresIn *int
+	if *tokenExpiresInPtr != 0 {
+		tokenExpiresIn = tokenExpiresInPtr
+	}
+
+	userService := services.NewUserService(db, services.DefaultLoginLockout, models.DefaultPasswordRules, services.DefaultBcryptHasher)
 
-	user, err := userService.AuthenticateUser(*emailPtr, *passwordPtr)
+	user, err := userService.AuthenticateUser(*emailPtr, *passwordPtr, "")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -43,7 +50,7 @@ func main() {
 		log.Println("User exists.")
 		return
 	}
-	_, err = userService.CreateUser(*emailPtr, *passwordPtr, *isAdminPtr, quota)
+	_, err = userService.CreateUser(*emailPtr, *passwordPtr, *isAdminPtr, quota, tokenExpiresIn)
 	if err != nil {
 		log.Fatal(err)
 	}
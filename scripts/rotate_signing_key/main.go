@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	"github.com/moonkeat/chainstack/services"
+)
+
+func main() {
+	algorithmPtr := flag.String("algorithm", services.JWTAlgorithmRS256, "signing algorithm of the new key (RS256 or EdDSA)")
+	retireAfterPtr := flag.Duration("retire-after", 24*time.Hour, "retire verify-only keys rotated out longer than this ago, must exceed the access token lifetime")
+	encryptExistingPtr := flag.Bool("encrypt-existing", false, "encrypt the keys stored in plain text instead of rotating")
+
+	flag.Parse()
+
+	dbConnString := os.Getenv("DB_CONNSTRING")
+	db, err := sqlx.Connect("postgres", dbConnString)
+	if err != nil {
+		log.Fatalf("Failed to connect to postgres, connString: '%s'", dbConnString)
+	}
+
+	encryptionKey, err := base64.StdEncoding.DecodeString(os.Getenv("SIGNING_KEY_ENCRYPTION_KEY"))
+	if err != nil || len(encryptionKey) != services.SigningKeyEncryptionKeySize {
+		log.Fatalf("SIGNING_KEY_ENCRYPTION_KEY should be %d base64 encoded bytes", services.SigningKeyEncryptionKeySize)
+	}
+
+	signingKeyService := services.NewSigningKeyService(db, encryptionKey)
+	if *encryptExistingPtr {
+		count, err := signingKeyService.EncryptSigningKeys()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("%d signing keys encrypted", count)
+		return
+	}
+
+	key, err := signingKeyService.RotateSigningKey(*algorithmPtr, *retireAfterPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Signing key rotated, new active key: %s (%s)", key.KeyID, key.Algorithm)
+}
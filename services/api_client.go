@@ -0,0 +1,133 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+type APIClientService interface {
+	CreateAPIClient(userID int, name string, scope []string, tokenExpiresIn *int, redirectURIs []string, public bool) (*models.APIClient, error)
+	GetAPIClient(clientID string) (*models.APIClient, error)
+	ListAPIClients(userID int) ([]models.APIClient, error)
+	DeleteAPIClient(userID int, clientID string) error
+	AuthenticateAPIClient(clientID string, secret string) (*models.APIClient, error)
+}
+
+type apiClientService struct {
+	DB *sqlx.DB
+}
+
+// CreateAPIClient generates a client id and secret for the user. The returned
+// client is the only place the plain secret is ever available. Public clients
+// get no secret.
+func (s apiClientService) CreateAPIClient(userID int, name string, scope []string, tokenExpiresIn *int, redirectURIs []string, public bool) (*models.APIClient, error) {
+	name = strings.TrimSpace(name)
+	err := models.ValidateAPIClient(name, tokenExpiresIn, redirectURIs, public)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret string
+	var secretHash []byte
+	if !public {
+		secretBytes := make([]byte, 32)
+		_, err = rand.Read(secretBytes)
+		if err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(secretBytes)
+
+		secretHash, err = bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client := models.APIClient{
+		ClientID:       uuid.NewV4().String(),
+		Name:           name,
+		Scope:          strings.Join(scope, " "),
+		TokenExpiresIn: tokenExpiresIn,
+		RedirectURIs:   pq.StringArray{},
+		Public:         public,
+		CreatedAt:      time.Now().UTC(),
+		UserID:         userID,
+	}
+	client.RedirectURIs = append(client.RedirectURIs, redirectURIs...)
+	_, err = s.DB.Exec("INSERT INTO api_clients (client_id, secret, name, scope, token_expires_in, redirect_uris, public, created_at, user_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)", client.ClientID, string(secretHash), client.Name, client.Scope, client.TokenExpiresIn, client.RedirectURIs, client.Public, client.CreatedAt, client.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	client.Secret = secret
+	return &client, nil
+}
+
+func (s apiClientService) GetAPIClient(clientID string) (*models.APIClient, error) {
+	client := models.APIClient{}
+	err := s.DB.Get(&client, "SELECT client_id, name, scope, token_expires_in, redirect_uris, public, created_at, user_id FROM api_clients WHERE client_id = $1", clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (s apiClientService) ListAPIClients(userID int) ([]models.APIClient, error) {
+	clients := []models.APIClient{}
+	err := s.DB.Select(&clients, "SELECT client_id, name, scope, token_expires_in, redirect_uris, public, created_at FROM api_clients WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+func (s apiClientService) DeleteAPIClient(userID int, clientID string) error {
+	client := models.APIClient{}
+	err := s.DB.Get(&client, "SELECT id FROM api_clients WHERE client_id = $1 AND user_id = $2", clientID, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec("DELETE FROM api_clients WHERE id = $1", client.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AuthenticateAPIClient returns nil without error if the client does not
+// exist, is public or the secret does not match.
+func (s apiClientService) AuthenticateAPIClient(clientID string, secret string) (*models.APIClient, error) {
+	client := models.APIClient{}
+	err := s.DB.Get(&client, "SELECT client_id, secret, name, scope, token_expires_in, redirect_uris, public, created_at, user_id FROM api_clients WHERE client_id = $1 AND NOT public", clientID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(client.Secret), []byte(secret)); err != nil {
+		return nil, nil
+	}
+
+	client.Secret = ""
+
+	return &client, nil
+}
+
+func NewAPIClientService(db *sqlx.DB) APIClientService {
+	return &apiClientService{
+		DB: db,
+	}
+}
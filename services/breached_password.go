@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+// breachedPasswordFile rejects the passwords of a Pwned Passwords SHA-1 file,
+// as written by the Pwned Passwords downloader from the k-anonymity hash
+// prefix ranges: one "<SHA-1>:<count>" line per password, sorted by hash. The
+// file is binary searched on disk, it is far too large to load in memory.
+type breachedPasswordFile struct {
+	File *os.File
+	Size int64
+}
+
+func (f breachedPasswordFile) ValidatePassword(email string, password string) error {
+	sum := sha1.Sum([]byte(password))
+	breached, err := f.contains(strings.ToUpper(hex.EncodeToString(sum[:])))
+	if err != nil {
+		return err
+	}
+
+	if breached {
+		return models.UserValidationError{
+			Field:  "password",
+			Reason: "password appears in a list of breached passwords",
+		}
+	}
+
+	return nil
+}
+
+// contains binary searches the file for the smallest offset where the next
+// line has a hash of at least hash, and tells whether that line is the hash.
+func (f breachedPasswordFile) contains(hash string) (bool, error) {
+	low, high := int64(0), f.Size
+	for low < high {
+		middle := low + (high-low)/2
+		lineHash, ok, err := f.hashAt(middle)
+		if err != nil {
+			return false, err
+		}
+
+		if ok && lineHash < hash {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+
+	lineHash, ok, err := f.hashAt(low)
+	if err != nil {
+		return false, err
+	}
+
+	return ok && lineHash == hash, nil
+}
+
+// hashAt returns the hash of the first line starting at or after offset, or
+// false at the end of the file.
+func (f breachedPasswordFile) hashAt(offset int64) (string, bool, error) {
+	start := offset
+	if start > 0 {
+		start--
+	}
+	reader := bufio.NewReaderSize(io.NewSectionReader(f.File, start, f.Size-start), 256)
+
+	if offset > 0 {
+		_, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", false, nil
+	}
+
+	return strings.ToUpper(strings.SplitN(line, ":", 2)[0]), true, nil
+}
+
+// breachedPasswordRanges rejects the passwords of a directory of Pwned
+// Passwords k-anonymity ranges, as written by the Pwned Passwords downloader
+// in parallel mode: one "<prefix>.txt" file per first five hex digits of the
+// SHA-1, with one "<suffix>:<count>" line per password. A range is a few
+// hundred lines, it is read whole. A missing range, such as of an interrupted
+// download, has no breached password.
+type breachedPasswordRanges struct {
+	Dir string
+}
+
+func (r breachedPasswordRanges) ValidatePassword(email string, password string) error {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	file, err := os.Open(filepath.Join(r.Dir, hash[:5]+".txt"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		suffix := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)[0]
+		if strings.ToUpper(suffix) == hash[5:] {
+			return models.UserValidationError{
+				Field:  "password",
+				Reason: "password appears in a list of breached passwords",
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// checkBreachedPasswordLine returns an error unless line is a "<hash>:<count>"
+// line with a hash of hashLength hex digits, or is empty.
+func checkBreachedPasswordLine(path string, line string, hashLength int) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+
+	parts := strings.SplitN(line, ":", 2)
+	notHex := func(r rune) bool {
+		return !strings.ContainsRune("0123456789ABCDEFabcdef", r)
+	}
+	if len(parts) != 2 || len(parts[0]) != hashLength || strings.IndexFunc(parts[0], notHex) != -1 {
+		return fmt.Errorf("'%s' is not in the Pwned Passwords format, lines should be a %d digit hex hash, a colon and a count", path, hashLength)
+	}
+
+	return nil
+}
+
+// NewBreachedPasswordFile returns a password policy rejecting the passwords of
+// the Pwned Passwords SHA-1 file at path, or of the directory of k-anonymity
+// ranges at path. The format is checked on the first line of the file, or of
+// the first range, so that a wrong file fails at startup rather than
+// accepting every password.
+func NewBreachedPasswordFile(path string) (models.PasswordPolicy, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		firstRange, err := os.Open(filepath.Join(path, "00000.txt"))
+		if err != nil {
+			return nil, err
+		}
+		defer firstRange.Close()
+
+		line, err := bufio.NewReader(firstRange).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		err = checkBreachedPasswordLine(firstRange.Name(), line, sha1.Size*2-5)
+		if err != nil {
+			return nil, err
+		}
+
+		return &breachedPasswordRanges{
+			Dir: path,
+		}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && err != io.EOF {
+		file.Close()
+		return nil, err
+	}
+
+	err = checkBreachedPasswordLine(path, line, sha1.Size*2)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &breachedPasswordFile{
+		File: file,
+		Size: info.Size(),
+	}, nil
+}
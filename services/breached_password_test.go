@@ -0,0 +1,178 @@
+package services_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/moonkeat/chainstack/models"
+	"github.com/moonkeat/chainstack/services"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func writeBreachedPasswordFile(t *testing.T, dir string, passwords []string, trailingNewline bool) string {
+	lines := []string{}
+	for i, password := range passwords {
+		lines = append(lines, sha1Hex(password)+":"+strings.Repeat("7", i%9+1))
+	}
+	sort.Strings(lines)
+
+	content := strings.Join(lines, "\r\n")
+	if trailingNewline {
+		content += "\r\n"
+	}
+
+	path := filepath.Join(dir, "pwnedpasswords.txt")
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// writeBreachedPasswordRanges writes the ranges of the breached passwords,
+// and the empty ranges of the other passwords, as the downloader writes every
+// range.
+func writeBreachedPasswordRanges(t *testing.T, dir string, passwords []string, otherPasswords []string) string {
+	ranges := map[string][]string{"00000": nil}
+	for _, password := range otherPasswords {
+		ranges[sha1Hex(password)[:5]] = nil
+	}
+	for _, password := range passwords {
+		hash := sha1Hex(password)
+		ranges[hash[:5]] = append(ranges[hash[:5]], hash[5:]+":1")
+	}
+
+	path := filepath.Join(dir, "pwnedpasswords")
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for prefix, lines := range ranges {
+		sort.Strings(lines)
+		if prefix == "00000" && len(lines) == 0 {
+			lines = []string{"0005AD76BD555C1D6D771DE417A4B87E4B4:10"}
+		}
+		err = ioutil.WriteFile(filepath.Join(path, prefix+".txt"), []byte(strings.Join(lines, "\r\n")), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path
+}
+
+func TestBreachedPasswordFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breachedpasswords")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	breached := []string{}
+	for i := 0; i < 500; i++ {
+		breached = append(breached, fmt.Sprintf("breached%d", i))
+	}
+
+	for _, trailingNewline := range []bool{true, false} {
+		path := writeBreachedPasswordFile(t, dir, breached, trailingNewline)
+		policy, err := services.NewBreachedPasswordFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Should reject every password of the file, including the first and
+		// the last line
+		for _, password := range breached {
+			err = policy.ValidatePassword("test@test.com", password)
+			if _, ok := err.(models.UserValidationError); !ok {
+				t.Errorf("breached password %q not rejected: %v", password, err)
+			}
+		}
+
+		// Should accept passwords that are not in the file
+		for _, password := range []string{"correct horse battery staple", "notbreached", ""} {
+			err = policy.ValidatePassword("test@test.com", password)
+			if err != nil {
+				t.Errorf("password %q rejected: %v", password, err)
+			}
+		}
+	}
+
+	// Should fail if the file does not exist
+	_, err = services.NewBreachedPasswordFile(filepath.Join(dir, "missing.txt"))
+	if err == nil {
+		t.Errorf("missing file accepted")
+	}
+
+	// Should accept every password with an empty file
+	path := writeBreachedPasswordFile(t, dir, nil, false)
+	policy, err := services.NewBreachedPasswordFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = policy.ValidatePassword("test@test.com", "password")
+	if err != nil {
+		t.Errorf("password rejected with an empty file: %v", err)
+	}
+
+	// Should fail if the file is not a SHA-1 file, such as concatenated
+	// ranges without their prefix
+	path = filepath.Join(dir, "ranges.txt")
+	err = ioutil.WriteFile(path, []byte("0005AD76BD555C1D6D771DE417A4B87E4B4:10\r\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = services.NewBreachedPasswordFile(path)
+	if err == nil {
+		t.Errorf("file of hash suffixes accepted")
+	}
+
+	// Should reject the passwords of a directory of ranges, and accept the
+	// others
+	notBreached := []string{"correct horse battery staple", "notbreached"}
+	path = writeBreachedPasswordRanges(t, dir, breached, notBreached)
+	policy, err = services.NewBreachedPasswordFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range breached {
+		err = policy.ValidatePassword("test@test.com", password)
+		if _, ok := err.(models.UserValidationError); !ok {
+			t.Errorf("breached password %q not rejected: %v", password, err)
+		}
+	}
+	for _, password := range notBreached {
+		err = policy.ValidatePassword("test@test.com", password)
+		if err != nil {
+			t.Errorf("password %q rejected: %v", password, err)
+		}
+	}
+
+	// Should accept the passwords of a missing range
+	err = os.Remove(filepath.Join(path, sha1Hex("notbreached")[:5]+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = policy.ValidatePassword("test@test.com", "notbreached")
+	if err != nil {
+		t.Errorf("password of a missing range rejected: %v", err)
+	}
+
+	// Should fail if a directory is not a directory of ranges
+	_, err = services.NewBreachedPasswordFile(dir)
+	if err == nil {
+		t.Errorf("directory without ranges accepted")
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeDBQuery answers a query of a fake database with the columns and rows of
+// its result. Statements without a result return no rows, and report the
+// number of rows as affected.
+type fakeDBQuery func(query string, args []driver.Value) ([]string, [][]driver.Value)
+
+var (
+	fakeDBMu      sync.Mutex
+	fakeDBQueries = map[string]fakeDBQuery{}
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// newFakeDB returns a database answering every query with query, so that
+// services can be tested without postgres.
+func newFakeDB(t *testing.T, query fakeDBQuery) *sqlx.DB {
+	fakeDBMu.Lock()
+	name := fmt.Sprintf("%s-%d", t.Name(), len(fakeDBQueries))
+	fakeDBQueries[name] = query
+	fakeDBMu.Unlock()
+
+	db, err := sqlx.Open("fakedb", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sqlx.NewDb(db.DB, "postgres")
+}
+
+type fakeDriver struct{}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBMu.Lock()
+	defer fakeDBMu.Unlock()
+
+	query, ok := fakeDBQueries[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fake database '%s'", name)
+	}
+
+	return &fakeConn{query: query}, nil
+}
+
+type fakeConn struct {
+	query fakeDBQuery
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows := c.query(query, namedValues(args))
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, rows := c.query(query, namedValues(args))
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, rows := s.conn.query(s.query, args)
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	columns, rows := s.conn.query(s.query, args)
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+type fakeTx struct{}
+
+func (tx fakeTx) Commit() error {
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
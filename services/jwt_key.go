@@ -0,0 +1,199 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+type JWTSignatureError struct{}
+
+func (e JWTSignatureError) Error() string {
+	return fmt.Sprint("jwt signature invalid")
+}
+
+// JWTKey signs and verifies JWTs with a single algorithm. HS256 keys hold a
+// shared secret, RS256 and EdDSA keys hold a private key and its public key.
+type JWTKey struct {
+	ID         string
+	Algorithm  string
+	secret     []byte
+	privateKey crypto.Signer
+	publicKey  crypto.PublicKey
+}
+
+// NewJWTKey builds a JWTKey for the given algorithm. HS256 expects the raw
+// secret, RS256 and EdDSA expect a PEM encoded PKCS#8 (or PKCS#1 for RSA)
+// private key.
+func NewJWTKey(id string, algorithm string, key []byte) (*JWTKey, error) {
+	switch algorithm {
+	case JWTAlgorithmHS256:
+		if len(key) < 32 {
+			return nil, fmt.Errorf("HS256 secret should be at least 32 bytes")
+		}
+		return &JWTKey{ID: id, Algorithm: algorithm, secret: key}, nil
+	case JWTAlgorithmRS256, JWTAlgorithmEdDSA:
+		signer, err := parsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		switch signer.(type) {
+		case *rsa.PrivateKey:
+			if algorithm != JWTAlgorithmRS256 {
+				return nil, fmt.Errorf("RSA private key can not be used with %s", algorithm)
+			}
+		case ed25519.PrivateKey:
+			if algorithm != JWTAlgorithmEdDSA {
+				return nil, fmt.Errorf("Ed25519 private key can not be used with %s", algorithm)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T", signer)
+		}
+
+		return &JWTKey{ID: id, Algorithm: algorithm, privateKey: signer, publicKey: signer.Public()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm: '%s'", algorithm)
+	}
+}
+
+func (k JWTKey) Sign(data []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case JWTAlgorithmHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case JWTAlgorithmRS256:
+		if k.privateKey == nil {
+			return nil, fmt.Errorf("jwt key '%s' can not sign", k.ID)
+		}
+		hashed := sha256.Sum256(data)
+		return k.privateKey.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	case JWTAlgorithmEdDSA:
+		if k.privateKey == nil {
+			return nil, fmt.Errorf("jwt key '%s' can not sign", k.ID)
+		}
+		return k.privateKey.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm: '%s'", k.Algorithm)
+	}
+}
+
+func (k JWTKey) Verify(data []byte, signature []byte) error {
+	switch k.Algorithm {
+	case JWTAlgorithmHS256:
+		expected, _ := k.Sign(data)
+		if !hmac.Equal(expected, signature) {
+			return JWTSignatureError{}
+		}
+	case JWTAlgorithmRS256:
+		publicKey, ok := k.publicKey.(*rsa.PublicKey)
+		if !ok {
+			return JWTSignatureError{}
+		}
+		hashed := sha256.Sum256(data)
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signature) != nil {
+			return JWTSignatureError{}
+		}
+	case JWTAlgorithmEdDSA:
+		publicKey, ok := k.publicKey.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(publicKey, data, signature) {
+			return JWTSignatureError{}
+		}
+	default:
+		return JWTSignatureError{}
+	}
+
+	return nil
+}
+
+// JSONWebKey returns the public key in JWK format. HS256 keys have no public
+// part and return false.
+func (k JWTKey) JSONWebKey() (*models.JSONWebKey, bool) {
+	switch publicKey := k.publicKey.(type) {
+	case *rsa.PublicKey:
+		return &models.JSONWebKey{
+			KeyType:   "RSA",
+			KeyID:     k.ID,
+			Use:       "sig",
+			Algorithm: k.Algorithm,
+			N:         base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return &models.JSONWebKey{
+			KeyType:   "OKP",
+			KeyID:     k.ID,
+			Use:       "sig",
+			Algorithm: k.Algorithm,
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(publicKey),
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// GeneratePrivateKey returns a new PEM encoded PKCS#8 private key for RS256
+// or EdDSA.
+func GeneratePrivateKey(algorithm string) ([]byte, error) {
+	var key interface{}
+	var err error
+	switch algorithm {
+	case JWTAlgorithmRS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case JWTAlgorithmEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("can not generate private key for jwt algorithm: '%s'", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func parsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key, err: %s", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return signer, nil
+}
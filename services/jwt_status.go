@@ -0,0 +1,155 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+// jwtStatusCacheDuration bounds how long a suspended or deleted user keeps
+// using JWT access tokens that were checked before, and how long revoking a
+// token takes to reach the other running instances.
+const jwtStatusCacheDuration = 10 * time.Second
+
+// JWTStatusStore checks what the signature of a JWT access token can not
+// tell, whether the user it was issued to is still active and whether the
+// token was revoked.
+type JWTStatusStore interface {
+	TokenActive(tokenID string, family string, userID int, issuedAt time.Time) (bool, error)
+	// RevokeToken rejects the token until it expires.
+	RevokeToken(tokenID string, expires time.Time) error
+	// RevokeFamily rejects the tokens issued with the refresh tokens of the
+	// family until expires.
+	RevokeFamily(family string, expires time.Time) error
+	// RevokeUserTokens rejects the tokens of the user issued so far. Tokens
+	// are issued with a precision of a millisecond, so tokens issued within
+	// the millisecond of the revocation are rejected as well.
+	RevokeUserTokens(userID int) error
+}
+
+type jwtStatus struct {
+	family    string
+	userID    int
+	active    bool
+	checkedAt time.Time
+}
+
+// jwtStatusStore remembers the status of a token for jwtStatusCacheDuration,
+// so that a token used for many requests costs one query per period rather
+// than one per request.
+type jwtStatusStore struct {
+	DB *sqlx.DB
+
+	mu        sync.Mutex
+	statuses  map[string]jwtStatus
+	cleanedAt time.Time
+	revokedAt time.Time
+}
+
+func (s *jwtStatusStore) TokenActive(tokenID string, family string, userID int, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	status, ok := s.statuses[tokenID]
+	s.mu.Unlock()
+	if ok && status.family == family && status.userID == userID && time.Since(status.checkedAt) < jwtStatusCacheDuration {
+		return status.active, nil
+	}
+
+	// The query runs without the lock, a slow database must not serialize
+	// every request.
+	queriedAt := time.Now()
+	var active bool
+	err := s.DB.Get(&active, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND status = $2 AND deleted_at IS NULL AND (tokens_revoked_at IS NULL OR tokens_revoked_at < $3)) AND NOT EXISTS(SELECT 1 FROM revoked_jwts WHERE jti = $4) AND NOT EXISTS(SELECT 1 FROM revoked_token_families WHERE family = $5)", userID, models.UserStatusActive, issuedAt, tokenID, family)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A revocation during the query may not be part of its result.
+	if s.revokedAt.After(queriedAt) {
+		return active, nil
+	}
+
+	now := time.Now()
+	if now.Sub(s.cleanedAt) > jwtStatusCacheDuration {
+		for id, status := range s.statuses {
+			if now.Sub(status.checkedAt) >= jwtStatusCacheDuration {
+				delete(s.statuses, id)
+			}
+		}
+		s.cleanedAt = now
+	}
+	s.statuses[tokenID] = jwtStatus{
+		family:    family,
+		userID:    userID,
+		active:    active,
+		checkedAt: now,
+	}
+
+	return active, nil
+}
+
+// RevokeToken, RevokeFamily and RevokeUserTokens forget the cached statuses right away, so
+// that this instance rejects the tokens on their next use.
+func (s *jwtStatusStore) RevokeToken(tokenID string, expires time.Time) error {
+	_, err := s.DB.Exec("INSERT INTO revoked_jwts (jti, expires) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING", tokenID, expires)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.statuses, tokenID)
+	s.revokedAt = time.Now()
+	return nil
+}
+
+func (s *jwtStatusStore) RevokeFamily(family string, expires time.Time) error {
+	_, err := s.DB.Exec("INSERT INTO revoked_token_families (family, expires) VALUES ($1, $2) ON CONFLICT (family) DO UPDATE SET expires = GREATEST(revoked_token_families.expires, EXCLUDED.expires)", family, expires)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, status := range s.statuses {
+		if status.family == family {
+			delete(s.statuses, id)
+		}
+	}
+	s.revokedAt = time.Now()
+	return nil
+}
+
+func (s *jwtStatusStore) RevokeUserTokens(userID int) error {
+	_, err := s.DB.Exec("UPDATE users SET tokens_revoked_at = $1 WHERE id = $2", time.Now().UTC(), userID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, status := range s.statuses {
+		if status.userID == userID {
+			delete(s.statuses, id)
+		}
+	}
+	s.revokedAt = time.Now()
+	return nil
+}
+
+// NewJWTStatusStore returns a status store backed by the users and
+// revoked_jwts tables.
+func NewJWTStatusStore(db *sqlx.DB) JWTStatusStore {
+	return &jwtStatusStore{
+		DB:       db,
+		statuses: map[string]jwtStatus{},
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJWTRevokeRefreshTokenFamily(t *testing.T) {
+	key, err := NewJWTKey("key1", JWTAlgorithmHS256, []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The refresh token "refreshtoken" is of the family "family1".
+	revokedFamilies := map[string]time.Time{}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, "WITH families AS (DELETE FROM refresh_tokens "):
+			if args[0] == hashToken("refreshtoken") {
+				return []string{"family"}, [][]driver.Value{{"family1"}}
+			}
+			return []string{"family"}, nil
+		case strings.HasPrefix(query, "INSERT INTO revoked_token_families "):
+			revokedFamilies[args[0].(string)] = args[1].(time.Time)
+		case strings.HasPrefix(query, "SELECT EXISTS("):
+			_, revoked := revokedFamilies[args[4].(string)]
+			return []string{"exists"}, [][]driver.Value{{!revoked}}
+		}
+		return nil, nil
+	})
+	tokenService := NewJWTTokenService(db, NewStaticJWTKeyStore(key), NewJWTStatusStore(db))
+
+	familyToken, err := tokenService.CreateToken(time.Hour, []string{"resources:read"}, 42, "family1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherFamilyToken, err := tokenService.CreateToken(time.Hour, []string{"resources:read"}, 42, "family2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokenService.AuthenticateToken(familyToken, "resources:read"); err != nil {
+		t.Fatalf("token of the family not authenticated: %v", err)
+	}
+
+	// Should reject the access tokens issued with a revoked refresh token,
+	// right away despite the cached status
+	err = tokenService.RevokeToken("refreshtoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expires, ok := revokedFamilies["family1"]; !ok || expires.Before(time.Now().Add(23*time.Hour)) {
+		t.Errorf("family not revoked for the longest access token lifetime: %v", revokedFamilies)
+	}
+	if _, err := tokenService.AuthenticateToken(familyToken, "resources:read"); err == nil {
+		t.Errorf("token of a revoked family authenticated")
+	}
+
+	// Should keep accepting the access tokens of other families
+	if _, err := tokenService.AuthenticateToken(otherFamilyToken, "resources:read"); err != nil {
+		t.Errorf("token of another family not authenticated: %v", err)
+	}
+}
+
+func TestJWTRevokeUserTokensWithinSecond(t *testing.T) {
+	key, err := NewJWTKey("key1", JWTAlgorithmHS256, []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokensRevokedAt time.Time
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, "UPDATE users SET tokens_revoked_at "):
+			tokensRevokedAt = args[0].(time.Time)
+		case strings.HasPrefix(query, "SELECT EXISTS("):
+			active := tokensRevokedAt.IsZero() || tokensRevokedAt.Before(args[2].(time.Time))
+			return []string{"exists"}, [][]driver.Value{{active}}
+		}
+		return nil, nil
+	})
+	tokenService := NewJWTTokenService(db, NewStaticJWTKeyStore(key), NewJWTStatusStore(db))
+
+	// Issue and revoke within the same second.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	revokedToken, err := tokenService.CreateToken(time.Hour, []string{"resources:read"}, 42, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	err = tokenService.RevokeUserTokens(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	newToken, err := tokenService.CreateToken(time.Hour, []string{"resources:read"}, 42, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Should reject the tokens issued before the revocation
+	if _, err := tokenService.AuthenticateToken(revokedToken, "resources:read"); err == nil {
+		t.Errorf("token issued before the revocation authenticated")
+	}
+
+	// Should accept the tokens issued after the revocation, within the same
+	// second
+	if _, err := tokenService.AuthenticateToken(newToken, "resources:read"); err != nil {
+		t.Errorf("token issued after the revocation not authenticated: %v", err)
+	}
+}
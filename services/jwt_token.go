@@ -0,0 +1,248 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// jwtClaims are the claims of an access token. fam is the family of the
+// refresh token issued with the token, if any. iat_ms is iat in milliseconds,
+// which tells the tokens issued right after a revocation of all tokens of the
+// user from the ones issued right before, within the same second.
+type jwtClaims struct {
+	ID               string `json:"jti"`
+	Family           string `json:"fam,omitempty"`
+	Subject          string `json:"sub"`
+	UserID           int    `json:"user_id"`
+	Scope            string `json:"scope"`
+	IssuedAt         int64  `json:"iat"`
+	IssuedAtMillisec int64  `json:"iat_ms,omitempty"`
+	ExpiresAt        int64  `json:"exp"`
+}
+
+// issuedAt returns iat_ms, or iat for the tokens issued without it.
+func (c jwtClaims) issuedAt() time.Time {
+	if c.IssuedAtMillisec != 0 {
+		return time.Unix(0, c.IssuedAtMillisec*int64(time.Millisecond)).UTC()
+	}
+
+	return time.Unix(c.IssuedAt, 0).UTC()
+}
+
+// jwtTokenService issues self-contained signed access tokens, so that
+// AuthenticateToken does not look every token up in the database. Whether the
+// user is still active and the token not revoked is checked with the
+// StatusStore, which caches it. Refresh tokens are still opaque and stored by
+// the embedded tokenService.
+type jwtTokenService struct {
+	tokenService
+	KeyStore    JWTKeyStore
+	StatusStore JWTStatusStore
+}
+
+func (s jwtTokenService) CreateToken(expiresIn time.Duration, scope []string, userID int, family string) (string, error) {
+	key, err := s.KeyStore.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	header, err := json.Marshal(jwtHeader{
+		Algorithm: key.Algorithm,
+		Type:      "JWT",
+		KeyID:     key.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(jwtClaims{
+		ID:               uuid.NewV4().String(),
+		Family:           family,
+		Subject:          strconv.Itoa(userID),
+		UserID:           userID,
+		Scope:            strings.Join(scope, " "),
+		IssuedAt:         now.Unix(),
+		IssuedAtMillisec: now.UnixNano() / int64(time.Millisecond),
+		ExpiresAt:        now.Add(expiresIn).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	signature, err := key.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (s jwtTokenService) AuthenticateToken(tokenString string, scope string) (*models.Token, error) {
+	if strings.Count(tokenString, ".") != 2 {
+		// Personal access tokens are opaque and stored in the database.
+		return s.tokenService.AuthenticateToken(tokenString, scope)
+	}
+
+	claims, err := s.verifyToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	token := models.Token{
+		Token:     tokenString,
+		Expires:   time.Unix(claims.ExpiresAt, 0).UTC(),
+		Scope:     claims.Scope,
+		UserID:    claims.UserID,
+		CreatedAt: claims.issuedAt(),
+	}
+
+	if !token.Expires.After(time.Now()) {
+		return nil, TokenAuthenticationError{}
+	}
+
+	if scope != "" && !models.ParseScope(token.Scope).Contains(scope) {
+		return nil, TokenAuthenticationError{}
+	}
+
+	active, err := s.StatusStore.TokenActive(claims.ID, claims.Family, claims.UserID, token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, TokenAuthenticationError{}
+	}
+
+	return &token, nil
+}
+
+// RevokeToken records the jti of a JWT access token so that it is rejected
+// until it expires, or removes the given opaque token. Revoking a refresh
+// token records its family, which rejects the JWTs issued with it until the
+// longest access token lifetime passed. JWTs with an invalid signature are
+// ignored like unknown tokens.
+func (s jwtTokenService) RevokeToken(tokenString string) error {
+	if strings.Count(tokenString, ".") != 2 {
+		families, err := s.tokenService.revokeToken(tokenString)
+		if err != nil {
+			return err
+		}
+
+		for _, family := range families {
+			err = s.StatusStore.RevokeFamily(family, time.Now().UTC().Add(models.TokenMaxExpiresIn))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	claims, err := s.verifyToken(tokenString)
+	if err != nil {
+		if _, ok := err.(TokenAuthenticationError); ok {
+			return nil
+		}
+		return err
+	}
+
+	expires := time.Unix(claims.ExpiresAt, 0).UTC()
+	if !expires.After(time.Now()) {
+		return nil
+	}
+
+	return s.StatusStore.RevokeToken(claims.ID, expires)
+}
+
+func (s jwtTokenService) RevokeUserTokens(userID int) error {
+	err := s.tokenService.RevokeUserTokens(userID)
+	if err != nil {
+		return err
+	}
+
+	return s.StatusStore.RevokeUserTokens(userID)
+}
+
+// verifyToken returns the claims of a JWT signed by one of the verification
+// keys, expired or not.
+func (s jwtTokenService) verifyToken(tokenString string) (*jwtClaims, error) {
+	parts := strings.Split(tokenString, ".")
+
+	header := jwtHeader{}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, TokenAuthenticationError{}
+	}
+
+	key, err := s.KeyStore.VerificationKey(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	// Never let the token pick the algorithm, otherwise an RS256 public key
+	// could be used as an HS256 secret.
+	if key == nil || header.Algorithm != key.Algorithm {
+		return nil, TokenAuthenticationError{}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, TokenAuthenticationError{}
+	}
+	if err := key.Verify([]byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, TokenAuthenticationError{}
+	}
+
+	claims := jwtClaims{}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, TokenAuthenticationError{}
+	}
+
+	return &claims, nil
+}
+
+func (s jwtTokenService) JSONWebKeys() ([]models.JSONWebKey, error) {
+	keys, err := s.KeyStore.VerificationKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	jsonWebKeys := []models.JSONWebKey{}
+	for _, key := range keys {
+		if jsonWebKey, ok := key.JSONWebKey(); ok {
+			jsonWebKeys = append(jsonWebKeys, *jsonWebKey)
+		}
+	}
+
+	return jsonWebKeys, nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func NewJWTTokenService(db *sqlx.DB, keyStore JWTKeyStore, statusStore JWTStatusStore) TokenService {
+	return &jwtTokenService{
+		tokenService: tokenService{DB: db},
+		KeyStore:     keyStore,
+		StatusStore:  statusStore,
+	}
+}
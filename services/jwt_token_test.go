@@ -0,0 +1,305 @@
+package services_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moonkeat/chainstack/models"
+	"github.com/moonkeat/chainstack/services"
+)
+
+func pemPrivateKey(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// fakeJWTStatusStore treats user 43 as suspended or deleted.
+type fakeJWTStatusStore struct {
+	revokedTokens   []string
+	revokedFamilies []string
+	revokedUsers    []int
+}
+
+func (s *fakeJWTStatusStore) TokenActive(tokenID string, family string, userID int, issuedAt time.Time) (bool, error) {
+	for _, revokedTokenID := range s.revokedTokens {
+		if revokedTokenID == tokenID {
+			return false, nil
+		}
+	}
+	for _, revokedFamily := range s.revokedFamilies {
+		if revokedFamily == family {
+			return false, nil
+		}
+	}
+	for _, revokedUserID := range s.revokedUsers {
+		if revokedUserID == userID {
+			return false, nil
+		}
+	}
+
+	return userID != 43, nil
+}
+
+func (s *fakeJWTStatusStore) RevokeToken(tokenID string, expires time.Time) error {
+	s.revokedTokens = append(s.revokedTokens, tokenID)
+	return nil
+}
+
+func (s *fakeJWTStatusStore) RevokeFamily(family string, expires time.Time) error {
+	s.revokedFamilies = append(s.revokedFamilies, family)
+	return nil
+}
+
+func (s *fakeJWTStatusStore) RevokeUserTokens(userID int) error {
+	s.revokedUsers = append(s.revokedUsers, userID)
+	return nil
+}
+
+func TestJWTTokenService(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := map[string][]byte{
+		services.JWTAlgorithmHS256: []byte("0123456789abcdef0123456789abcdef"),
+		services.JWTAlgorithmRS256: pemPrivateKey(t, rsaKey),
+		services.JWTAlgorithmEdDSA: pemPrivateKey(t, edKey),
+	}
+
+	for algorithm, keyData := range keys {
+		key, err := services.NewJWTKey("key1", algorithm, keyData)
+		if err != nil {
+			t.Fatalf("%s: failed to create key: %s", algorithm, err)
+		}
+		tokenService := services.NewJWTTokenService(nil, services.NewStaticJWTKeyStore(key), &fakeJWTStatusStore{})
+
+		// Should authenticate a token it issued
+		tokenString, err := tokenService.CreateToken(time.Hour, []string{models.ScopeResourcesRead, models.ScopeUsersRead}, 42, "")
+		if err != nil {
+			t.Fatalf("%s: failed to create token: %s", algorithm, err)
+		}
+		token, err := tokenService.AuthenticateToken(tokenString, models.ScopeUsersRead)
+		if err != nil {
+			t.Fatalf("%s: failed to authenticate token: %s", algorithm, err)
+		}
+		if token.UserID != 42 || token.Scope != "resources:read users:read" {
+			t.Errorf("%s: unexpected token: %+v", algorithm, token)
+		}
+
+		// Should reject a token without the requested scope
+		tokenString, err = tokenService.CreateToken(time.Hour, []string{models.ScopeResourcesRead}, 42, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tokenService.AuthenticateToken(tokenString, models.ScopeResourcesWrite); err == nil {
+			t.Errorf("%s: token without scope should be rejected", algorithm)
+		}
+
+		// Should reject a tampered token
+		parts := strings.Split(tokenString, ".")
+		tampered := parts[0] + "." + parts[1] + "x." + parts[2]
+		if _, err := tokenService.AuthenticateToken(tampered, models.ScopeResourcesRead); err == nil {
+			t.Errorf("%s: tampered token should be rejected", algorithm)
+		}
+
+		// Should publish public keys only
+		jsonWebKeys, err := tokenService.JSONWebKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if algorithm == services.JWTAlgorithmHS256 && len(jsonWebKeys) != 0 {
+			t.Errorf("%s: secret key should not be published", algorithm)
+		}
+		if algorithm != services.JWTAlgorithmHS256 && (len(jsonWebKeys) != 1 || jsonWebKeys[0].KeyID != "key1" || jsonWebKeys[0].Algorithm != algorithm) {
+			t.Errorf("%s: unexpected json web keys: %+v", algorithm, jsonWebKeys)
+		}
+
+		// Should reject a token of a user who is no longer active
+		tokenString, err = tokenService.CreateToken(time.Hour, []string{models.ScopeResourcesRead}, 43, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tokenService.AuthenticateToken(tokenString, models.ScopeResourcesRead); err == nil {
+			t.Errorf("%s: token of an inactive user should be rejected", algorithm)
+		}
+
+		// Should reject a revoked token, and keep the other tokens of the user
+		tokenString, err = tokenService.CreateToken(time.Hour, []string{models.ScopeResourcesRead}, 42, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherTokenString, err := tokenService.CreateToken(time.Hour, []string{models.ScopeResourcesRead}, 42, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tokenService.RevokeToken(tokenString); err != nil {
+			t.Fatalf("%s: failed to revoke token: %s", algorithm, err)
+		}
+		if _, err := tokenService.AuthenticateToken(tokenString, models.ScopeResourcesRead); err == nil {
+			t.Errorf("%s: revoked token should be rejected", algorithm)
+		}
+		if _, err := tokenService.AuthenticateToken(otherTokenString, models.ScopeResourcesRead); err != nil {
+			t.Errorf("%s: token not revoked should be accepted, err: %s", algorithm, err)
+		}
+
+		// Should ignore revoking a tampered token
+		parts = strings.Split(otherTokenString, ".")
+		tampered = parts[0] + "." + parts[1] + "x." + parts[2]
+		if err := tokenService.RevokeToken(tampered); err != nil {
+			t.Errorf("%s: revoking a tampered token should be ignored, err: %s", algorithm, err)
+		}
+		if _, err := tokenService.AuthenticateToken(otherTokenString, models.ScopeResourcesRead); err != nil {
+			t.Errorf("%s: token not revoked should be accepted, err: %s", algorithm, err)
+		}
+
+		// Should reject an expired token
+		tokenString, err = tokenService.CreateToken(-time.Minute, []string{models.ScopeResourcesRead}, 42, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tokenService.AuthenticateToken(tokenString, models.ScopeResourcesRead); err == nil {
+			t.Errorf("%s: expired token should be rejected", algorithm)
+		}
+	}
+
+	// Should reject a token signed with another algorithm
+	hsKey, _ := services.NewJWTKey("key1", services.JWTAlgorithmHS256, keys[services.JWTAlgorithmHS256])
+	edJWTKey, _ := services.NewJWTKey("key1", services.JWTAlgorithmEdDSA, keys[services.JWTAlgorithmEdDSA])
+	tokenString, err := services.NewJWTTokenService(nil, services.NewStaticJWTKeyStore(hsKey), &fakeJWTStatusStore{}).CreateToken(time.Hour, []string{models.ScopeResourcesRead}, 42, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.NewJWTTokenService(nil, services.NewStaticJWTKeyStore(edJWTKey), &fakeJWTStatusStore{}).AuthenticateToken(tokenString, models.ScopeResourcesRead); err == nil {
+		t.Errorf("token signed with another algorithm should be rejected")
+	}
+
+	// Should refuse keys that do not match the algorithm
+	if _, err := services.NewJWTKey("key1", services.JWTAlgorithmEdDSA, keys[services.JWTAlgorithmRS256]); err == nil {
+		t.Errorf("RSA key should not be accepted for EdDSA")
+	}
+	if _, err := services.NewJWTKey("key1", services.JWTAlgorithmHS256, []byte("short")); err == nil {
+		t.Errorf("short HS256 secret should not be accepted")
+	}
+}
+
+var signingKeyEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+type fakeSigningKeyService struct {
+	keys []models.SigningKey
+}
+
+func (s *fakeSigningKeyService) RotateSigningKey(algorithm string, retireAfter time.Duration) (*models.SigningKey, error) {
+	privateKey, err := services.GeneratePrivateKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := services.EncryptSigningKey(signingKeyEncryptionKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range s.keys {
+		if s.keys[i].Status == models.SigningKeyStatusActive {
+			s.keys[i].Status = models.SigningKeyStatusVerifyOnly
+		}
+	}
+
+	key := models.SigningKey{
+		KeyID:      fmt.Sprintf("key%d", len(s.keys)+1),
+		Algorithm:  algorithm,
+		PrivateKey: encryptedKey,
+		Status:     models.SigningKeyStatusActive,
+	}
+	s.keys = append(s.keys, key)
+	return &key, nil
+}
+
+func (s *fakeSigningKeyService) ListSigningKeys() ([]models.SigningKey, error) {
+	return s.keys, nil
+}
+
+func (s *fakeSigningKeyService) EncryptSigningKeys() (int, error) {
+	return 0, nil
+}
+
+func TestSigningKeyStore(t *testing.T) {
+	signingKeyService := &fakeSigningKeyService{}
+	tokenService := services.NewJWTTokenService(nil, services.NewSigningKeyStore(signingKeyService, signingKeyEncryptionKey), &fakeJWTStatusStore{})
+
+	// Should fail to sign without an active key
+	if _, err := tokenService.CreateToken(time.Hour, []string{models.ScopeResourcesRead}, 42, ""); err == nil {
+		t.Errorf("token should not be signed without an active key")
+	}
+
+	_, err := signingKeyService.RotateSigningKey(services.JWTAlgorithmEdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Should sign with the active key
+	tokenService = services.NewJWTTokenService(nil, services.NewSigningKeyStore(signingKeyService, signingKeyEncryptionKey), &fakeJWTStatusStore{})
+	oldToken, err := tokenService.CreateToken(time.Hour, []string{models.ScopeResourcesRead}, 42, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Should keep verifying tokens signed by a rotated key
+	_, err = signingKeyService.RotateSigningKey(services.JWTAlgorithmRS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenService = services.NewJWTTokenService(nil, services.NewSigningKeyStore(signingKeyService, signingKeyEncryptionKey), &fakeJWTStatusStore{})
+	if _, err := tokenService.AuthenticateToken(oldToken, models.ScopeResourcesRead); err != nil {
+		t.Errorf("token signed by verify-only key should be accepted, err: %s", err)
+	}
+
+	newToken, err := tokenService.CreateToken(time.Hour, []string{models.ScopeResourcesRead}, 42, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := strings.Split(newToken, ".")[0]; header == strings.Split(oldToken, ".")[0] {
+		t.Errorf("token should be signed by the new active key")
+	}
+
+	// Should publish active and verify-only keys, active first
+	jsonWebKeys, err := tokenService.JSONWebKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jsonWebKeys) != 2 || jsonWebKeys[0].KeyID != "key2" || jsonWebKeys[1].KeyID != "key1" {
+		t.Errorf("unexpected json web keys: %+v", jsonWebKeys)
+	}
+
+	// Should not load keys with another encryption key
+	tokenService = services.NewJWTTokenService(nil, services.NewSigningKeyStore(signingKeyService, []byte("fedcba9876543210fedcba9876543210")), &fakeJWTStatusStore{})
+	if _, err := tokenService.CreateToken(time.Hour, []string{models.ScopeResourcesRead}, 42, ""); err == nil {
+		t.Errorf("token should not be signed with keys encrypted under another key")
+	}
+
+	// Should refuse keys stored in plain text
+	privateKey, err := services.GeneratePrivateKey(services.JWTAlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKeyService.keys[0].PrivateKey = string(privateKey)
+	tokenService = services.NewJWTTokenService(nil, services.NewSigningKeyStore(signingKeyService, signingKeyEncryptionKey), &fakeJWTStatusStore{})
+	if _, err := tokenService.CreateToken(time.Hour, []string{models.ScopeResourcesRead}, 42, ""); err == nil || !strings.Contains(err.Error(), "not encrypted") {
+		t.Errorf("keys stored in plain text should be refused, err: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+const (
+	// MFAEncryptionKeySize is the size of the AES-256 key encrypting TOTP
+	// secrets.
+	MFAEncryptionKeySize = 32
+
+	// DefaultMFAIssuer names the account in authenticator apps.
+	DefaultMFAIssuer = "Chainstack"
+)
+
+type MFAService interface {
+	EnrollMFA(user *models.User) (*models.MFAEnrollment, error)
+	ConfirmMFA(userID int, code string) ([]string, error)
+	GetMFA(userID int) (*models.MFA, error)
+	DisableMFA(userID int) error
+	RegenerateRecoveryCodes(userID int) ([]string, error)
+	VerifyMFA(userID int, code string) (bool, error)
+}
+
+type MFANotConfiguredError struct{}
+
+func (e MFANotConfiguredError) Error() string {
+	return "two-factor authentication is not configured"
+}
+
+type MFAAlreadyEnabledError struct{}
+
+func (e MFAAlreadyEnabledError) Error() string {
+	return "two-factor authentication is already enabled"
+}
+
+type MFAInvalidCodeError struct{}
+
+func (e MFAInvalidCodeError) Error() string {
+	return "invalid code"
+}
+
+type mfaService struct {
+	DB            *sqlx.DB
+	EncryptionKey []byte
+	Issuer        string
+}
+
+// EnrollMFA generates a new TOTP secret for the user, replacing any secret
+// that was never confirmed. Two-factor authentication is only enabled once
+// ConfirmMFA proves the user set up an authenticator app.
+func (s mfaService) EnrollMFA(user *models.User) (*models.MFAEnrollment, error) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedSecret, err := s.encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.DB.Exec("INSERT INTO user_mfa (user_id, secret) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = NOW() WHERE NOT user_mfa.confirmed", user.ID, encryptedSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, MFAAlreadyEnabledError{}
+	}
+
+	encodedSecret := totpEncoding.EncodeToString(secret)
+	otpauthURI := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + s.Issuer + ":" + user.Email,
+		RawQuery: url.Values{
+			"secret":    {encodedSecret},
+			"issuer":    {s.Issuer},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(totpDigits)},
+			"period":    {fmt.Sprint(totpPeriod)},
+		}.Encode(),
+	}
+
+	return &models.MFAEnrollment{
+		Secret:     encodedSecret,
+		OTPAuthURI: otpauthURI.String(),
+	}, nil
+}
+
+// ConfirmMFA enables two-factor authentication if the code matches the
+// enrolled secret, and returns the recovery codes of the user.
+func (s mfaService) ConfirmMFA(userID int, code string) ([]string, error) {
+	var enrollment struct {
+		Secret    string `db:"secret"`
+		Confirmed bool   `db:"confirmed"`
+	}
+	err := s.DB.Get(&enrollment, "SELECT secret, confirmed FROM user_mfa WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if enrollment.Confirmed {
+		return nil, MFAAlreadyEnabledError{}
+	}
+
+	secret, err := s.decrypt(enrollment.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, MFAInvalidCodeError{}
+	}
+
+	recoveryCodes, hashedRecoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.DB.Exec("UPDATE user_mfa SET confirmed = TRUE, last_used_step = $2, recovery_codes = $3 WHERE user_id = $1", userID, step, hashedRecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+func (s mfaService) GetMFA(userID int) (*models.MFA, error) {
+	mfa := models.MFA{}
+	err := s.DB.Get(&mfa, "SELECT confirmed, cardinality(recovery_codes) AS recovery_codes_remaining FROM user_mfa WHERE user_id = $1 AND confirmed", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+func (s mfaService) DisableMFA(userID int) error {
+	result, err := s.DB.Exec("DELETE FROM user_mfa WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user with two-factor
+// authentication enabled, the previous codes stop working.
+func (s mfaService) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	recoveryCodes, hashedRecoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.DB.Exec("UPDATE user_mfa SET recovery_codes = $2 WHERE user_id = $1 AND confirmed", userID, hashedRecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return recoveryCodes, nil
+}
+
+// VerifyMFA checks a one-time code of a user with two-factor authentication
+// enabled. The code is either a TOTP code, which can not be used twice, or a
+// recovery code, which is consumed.
+func (s mfaService) VerifyMFA(userID int, code string) (bool, error) {
+	var encryptedSecret string
+	err := s.DB.Get(&encryptedSecret, "SELECT secret FROM user_mfa WHERE user_id = $1 AND confirmed", userID)
+	if err != nil {
+		return false, err
+	}
+
+	secret, err := s.decrypt(encryptedSecret)
+	if err != nil {
+		return false, err
+	}
+
+	var result sql.Result
+	if step, ok := matchTOTP(secret, code, time.Now()); ok {
+		result, err = s.DB.Exec("UPDATE user_mfa SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2", userID, step)
+	} else {
+		result, err = s.DB.Exec("UPDATE user_mfa SET recovery_codes = array_remove(recovery_codes, $2) WHERE user_id = $1 AND $2 = ANY(recovery_codes)", userID, hashRecoveryCode(code))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (s mfaService) encrypt(plaintext []byte) (string, error) {
+	if len(s.EncryptionKey) == 0 {
+		return "", MFANotConfiguredError{}
+	}
+
+	return encrypt(s.EncryptionKey, plaintext)
+}
+
+func (s mfaService) decrypt(encrypted string) ([]byte, error) {
+	if len(s.EncryptionKey) == 0 {
+		return nil, MFANotConfiguredError{}
+	}
+
+	return decrypt(s.EncryptionKey, encrypted)
+}
+
+// generateRecoveryCodes returns new recovery codes formatted for the user, and
+// their hashes to store.
+func generateRecoveryCodes() ([]string, pq.StringArray, error) {
+	recoveryCodes := []string{}
+	hashedRecoveryCodes := pq.StringArray{}
+	for i := 0; i < models.MFARecoveryCodes; i++ {
+		random := make([]byte, 10)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(random))
+		recoveryCodes = append(recoveryCodes, code[:8]+"-"+code[8:])
+		hashedRecoveryCodes = append(hashedRecoveryCodes, hashRecoveryCode(code))
+	}
+
+	return recoveryCodes, hashedRecoveryCodes, nil
+}
+
+// hashRecoveryCode hashes a recovery code the way the user may type it, in any
+// case and with or without the dash.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.Replace(code, "-", "", -1)
+	return hashToken(code)
+}
+
+// NewMFAService returns the two-factor authentication service. TOTP secrets are
+// encrypted with the AES-256 encryptionKey, without a key every operation
+// fails with MFANotConfiguredError.
+func NewMFAService(db *sqlx.DB, encryptionKey []byte, issuer string) MFAService {
+	return &mfaService{
+		DB:            db,
+		EncryptionKey: encryptionKey,
+		Issuer:        issuer,
+	}
+}
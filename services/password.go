@@ -0,0 +1,168 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordHasherBcrypt   = "bcrypt"
+	PasswordHasherArgon2id = "argon2id"
+)
+
+// PasswordHasher hashes the passwords of users. Hashes are encoded with their
+// algorithm and parameters, so that passwords hashed with another algorithm
+// or parameters can still be checked, and upgraded on the next login.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// NeedsRehash tells whether the hash was made with another algorithm or
+	// weaker parameters than the hasher's.
+	NeedsRehash(passwordHash string) bool
+}
+
+// BcryptHasher hashes passwords with bcrypt, encoded as "$2a$<cost>$...".
+type BcryptHasher struct {
+	Cost int
+}
+
+var DefaultBcryptHasher = BcryptHasher{
+	Cost: bcrypt.DefaultCost,
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(passwordHash), nil
+}
+
+func (h BcryptHasher) NeedsRehash(passwordHash string) bool {
+	cost, err := bcrypt.Cost([]byte(passwordHash))
+	if err != nil {
+		return true
+	}
+
+	return cost < h.Cost
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoded in the PHC string
+// format "$argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>".
+// Memory is in KiB.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultArgon2idHasher has the parameters RFC 9106 recommends for memory
+// constrained environments.
+var DefaultArgon2idHasher = Argon2idHasher{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+}
+
+const (
+	argon2idSaltLength = 16
+	argon2idKeyLength  = 32
+)
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, argon2idKeyLength)
+	return encodeArgon2id(h, salt, key), nil
+}
+
+func (h Argon2idHasher) NeedsRehash(passwordHash string) bool {
+	params, _, key, err := decodeArgon2id(passwordHash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory < h.Memory ||
+		params.Iterations < h.Iterations ||
+		params.Parallelism < h.Parallelism ||
+		len(key) < argon2idKeyLength
+}
+
+func encodeArgon2id(params Argon2idHasher, salt []byte, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2id(passwordHash string) (Argon2idHasher, []byte, []byte, error) {
+	params := Argon2idHasher{}
+	parts := strings.Split(passwordHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version: %d", version)
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	return params, salt, key, nil
+}
+
+// comparePassword tells whether the password matches the hash, whatever the
+// algorithm of the hash.
+func comparePassword(passwordHash string, password string) bool {
+	if strings.HasPrefix(passwordHash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(passwordHash)
+		if err != nil || len(key) == 0 {
+			return false
+		}
+
+		computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(computed, key) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+}
+
+// checkPassword tells whether the password matches the hash. Unknown users
+// have an empty hash, which is checked against dummyPasswordHash instead, a
+// hash of the current hasher, so that it takes as long as a wrong password and
+// response times do not tell which emails are registered.
+func checkPassword(passwordHash string, dummyPasswordHash string, password string) bool {
+	if passwordHash == "" {
+		comparePassword(dummyPasswordHash, password)
+		return false
+	}
+
+	return comparePassword(passwordHash, password)
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"database/sql/driver"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+// timingRuns is the number of runs of each path, odd to have a single median.
+const timingRuns = 9
+
+// timingTolerance is the largest difference between the median durations of
+// two paths, relative to the slowest, considered indistinguishable.
+const timingTolerance = 0.2
+
+// timingAttempts is how many times two paths are compared before they are
+// considered distinguishable. A timing leak shows in every comparison, while
+// a load spike on the machine, such as other test packages running in
+// parallel, rarely does twice in a row.
+const timingAttempts = 3
+
+// compareTimings runs both functions timingRuns times, interleaved so that
+// load on the machine affects both alike, and returns their median durations.
+func compareTimings(a func(), b func()) (time.Duration, time.Duration) {
+	aDurations := make([]time.Duration, timingRuns)
+	bDurations := make([]time.Duration, timingRuns)
+	for i := 0; i < timingRuns; i++ {
+		start := time.Now()
+		a()
+		aDurations[i] = time.Since(start)
+
+		start = time.Now()
+		b()
+		bDurations[i] = time.Since(start)
+	}
+
+	return medianDuration(aDurations), medianDuration(bDurations)
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[len(durations)/2]
+}
+
+// compareTimingsAgain compares the timings of both functions until they are
+// indistinguishable, at most timingAttempts times, and returns the medians of
+// the last comparison.
+func compareTimingsAgain(a func(), b func()) (time.Duration, time.Duration) {
+	var aDuration, bDuration time.Duration
+	for i := 0; i < timingAttempts; i++ {
+		aDuration, bDuration = compareTimings(a, b)
+		if indistinguishable(aDuration, bDuration) {
+			break
+		}
+	}
+
+	return aDuration, bDuration
+}
+
+func indistinguishable(a time.Duration, b time.Duration) bool {
+	slowest, fastest := a, b
+	if b > a {
+		slowest, fastest = b, a
+	}
+
+	return float64(slowest-fastest) <= float64(slowest)*timingTolerance
+}
+
+// testPasswordHashers are cheap enough to hash many times in tests.
+var testPasswordHashers = map[string]PasswordHasher{
+	PasswordHasherBcrypt:   BcryptHasher{Cost: bcrypt.MinCost},
+	PasswordHasherArgon2id: Argon2idHasher{Memory: 8 * 1024, Iterations: 1, Parallelism: 1},
+}
+
+func TestCheckPassword(t *testing.T) {
+	for name, hasher := range testPasswordHashers {
+		passwordHash, err := hasher.Hash("correctpassword")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dummyPasswordHash, err := hasher.Hash("dummy password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Should match the correct password
+		if !checkPassword(passwordHash, dummyPasswordHash, "correctpassword") {
+			t.Errorf("%s: checkPassword returned false for the correct password", name)
+		}
+
+		// Should not match a wrong password
+		if checkPassword(passwordHash, dummyPasswordHash, "wrongpassword") {
+			t.Errorf("%s: checkPassword returned true for a wrong password", name)
+		}
+
+		// Should not match any password of an unknown user
+		if checkPassword("", dummyPasswordHash, "dummy password") {
+			t.Errorf("%s: checkPassword returned true for an unknown user", name)
+		}
+
+		// Should match hashes of the other hashers
+		for otherName, other := range testPasswordHashers {
+			otherHash, err := other.Hash("correctpassword")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !checkPassword(otherHash, dummyPasswordHash, "correctpassword") {
+				t.Errorf("%s: checkPassword returned false for the correct password of a %s hash", name, otherName)
+			}
+		}
+	}
+
+	// Should not match a malformed hash
+	if checkPassword("$argon2id$v=19$m=8192,t=1,p=1$c2FsdA$", "", "") {
+		t.Errorf("checkPassword returned true for a malformed hash")
+	}
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	hash := func(hasher PasswordHasher) string {
+		passwordHash, err := hasher.Hash("correctpassword")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return passwordHash
+	}
+
+	argon2idHasher := Argon2idHasher{Memory: 8 * 1024, Iterations: 2, Parallelism: 2}
+	tests := []struct {
+		name         string
+		hasher       PasswordHasher
+		passwordHash string
+		needsRehash  bool
+	}{
+		{"bcrypt with a lower cost", BcryptHasher{Cost: 5}, hash(BcryptHasher{Cost: 4}), true},
+		{"bcrypt with the same cost", BcryptHasher{Cost: 5}, hash(BcryptHasher{Cost: 5}), false},
+		{"bcrypt with a higher cost", BcryptHasher{Cost: 4}, hash(BcryptHasher{Cost: 5}), false},
+		{"argon2id hash with bcrypt", BcryptHasher{Cost: 4}, hash(argon2idHasher), true},
+		{"bcrypt hash with argon2id", argon2idHasher, hash(BcryptHasher{Cost: 4}), true},
+		{"argon2id with the same parameters", argon2idHasher, hash(argon2idHasher), false},
+		{"argon2id with less memory", argon2idHasher, hash(Argon2idHasher{Memory: 4 * 1024, Iterations: 2, Parallelism: 2}), true},
+		{"argon2id with fewer iterations", argon2idHasher, hash(Argon2idHasher{Memory: 8 * 1024, Iterations: 1, Parallelism: 2}), true},
+		{"argon2id with lower parallelism", argon2idHasher, hash(Argon2idHasher{Memory: 8 * 1024, Iterations: 2, Parallelism: 1}), true},
+		{"argon2id with stronger parameters", argon2idHasher, hash(Argon2idHasher{Memory: 16 * 1024, Iterations: 3, Parallelism: 2}), false},
+		{"malformed hash with argon2id", argon2idHasher, "$argon2id$v=19$m=8192", true},
+		{"empty hash with bcrypt", BcryptHasher{Cost: 4}, "", true},
+	}
+
+	for _, test := range tests {
+		// Should only rehash hashes of another algorithm or weaker parameters
+		if needsRehash := test.hasher.NeedsRehash(test.passwordHash); needsRehash != test.needsRehash {
+			t.Errorf("%s: NeedsRehash returned %v, want %v", test.name, needsRehash, test.needsRehash)
+		}
+	}
+}
+
+func TestCheckPasswordTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping timing test in short mode")
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Should tell apart an early return from a bcrypt comparison, otherwise
+	// the harness proves nothing
+	wrongPassword, earlyReturn := compareTimings(func() {
+		bcrypt.CompareHashAndPassword(passwordHash, []byte("wrongpassword"))
+	}, func() {
+		bcrypt.CompareHashAndPassword(nil, []byte("wrongpassword"))
+	})
+	if indistinguishable(wrongPassword, earlyReturn) {
+		t.Fatalf("harness can not tell apart a wrong password (%v) from an early return (%v)", wrongPassword, earlyReturn)
+	}
+
+	hashers := map[string]PasswordHasher{
+		PasswordHasherBcrypt:   DefaultBcryptHasher,
+		PasswordHasherArgon2id: Argon2idHasher{Memory: 16 * 1024, Iterations: 2, Parallelism: 1},
+	}
+	for name, hasher := range hashers {
+		passwordHash, err := hasher.Hash("correctpassword")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// known@email.com is the only user, failed logins are counted for
+		// any email.
+		db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			switch {
+			case strings.HasPrefix(query, "SELECT password, ") && args[0] == "known@email.com":
+				return []string{"password", "id", "email", "status"}, [][]driver.Value{{passwordHash, int64(1), "known@email.com", models.UserStatusActive}}
+			case strings.HasPrefix(query, "INSERT INTO email_login_attempts "):
+				return []string{"failed_login_attempts"}, [][]driver.Value{{int64(1)}}
+			}
+			return nil, nil
+		})
+		userService := NewUserService(db, DefaultLoginLockout, models.DefaultPasswordRules, hasher)
+
+		// Should authenticate the known user with the correct password only,
+		// otherwise the timings below compare failures of something else
+		user, err := userService.AuthenticateUser("known@email.com", "correctpassword", "")
+		if err != nil || user == nil || user.ID != 1 {
+			t.Fatalf("%s: known user not authenticated: %v, %v", name, user, err)
+		}
+		for _, email := range []string{"known@email.com", "unknown@email.com"} {
+			user, err = userService.AuthenticateUser(email, "wrongpassword", "")
+			if err != nil || user != nil {
+				t.Fatalf("%s: %s authenticated with a wrong password: %v, %v", name, email, user, err)
+			}
+		}
+
+		// Should take as long to reject an unknown email as a wrong password
+		// of a known one
+		wrongPassword, unknownUser := compareTimingsAgain(func() {
+			userService.AuthenticateUser("known@email.com", "wrongpassword", "")
+		}, func() {
+			userService.AuthenticateUser("unknown@email.com", "wrongpassword", "")
+		})
+		if !indistinguishable(wrongPassword, unknownUser) {
+			t.Errorf("%s: unknown user took %v to reject, wrong password took %v", name, unknownUser, wrongPassword)
+		}
+	}
+}
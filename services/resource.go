@@ -14,7 +14,9 @@ type ResourceService interface {
 	CreateResource(userID int) (*models.Resource, error)
 	GetResource(userID int, key string) (*models.Resource, error)
 	DeleteResource(userID int, key string) error
+	RestoreResource(userID int, key string) (*models.Resource, error)
 	ListResources(userID int) ([]models.Resource, error)
+	PurgeDeletedResources(retention time.Duration) error
 }
 
 type resourceService struct {
@@ -34,7 +36,7 @@ func (s resourceService) CreateResource(userID int) (*models.Resource, error) {
 
 func (s resourceService) GetResource(userID int, key string) (*models.Resource, error) {
 	resource := models.Resource{}
-	err := s.DB.Get(&resource, "SELECT key, created_at FROM resources WHERE key = $1 AND user_id = $2", key, userID)
+	err := s.DB.Get(&resource, "SELECT key, created_at FROM resources WHERE key = $1 AND user_id = $2 AND deleted_at IS NULL", key, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -42,24 +44,31 @@ func (s resourceService) GetResource(userID int, key string) (*models.Resource,
 	return &resource, nil
 }
 
+// DeleteResource soft deletes the resource, it is hidden and no longer counts
+// against the quota, but can be restored until purged.
 func (s resourceService) DeleteResource(userID int, key string) error {
 	resource := models.Resource{}
-	err := s.DB.Get(&resource, "SELECT id FROM resources WHERE key = $1 AND user_id = $2", key, userID)
+	err := s.DB.Get(&resource, "UPDATE resources SET deleted_at = $1 WHERE key = $2 AND user_id = $3 AND deleted_at IS NULL RETURNING id", time.Now().UTC(), key, userID)
 	if err != nil {
 		return err
 	}
 
-	_, err = s.DB.Query("DELETE FROM resources WHERE id = $1", resource.ID)
+	return nil
+}
+
+func (s resourceService) RestoreResource(userID int, key string) (*models.Resource, error) {
+	resource := models.Resource{}
+	err := s.DB.Get(&resource, "UPDATE resources SET deleted_at = NULL WHERE key = $1 AND user_id = $2 AND deleted_at IS NOT NULL RETURNING key, created_at", key, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &resource, nil
 }
 
 func (s resourceService) ListResources(userID int) ([]models.Resource, error) {
 	resources := []models.Resource{}
-	err := s.DB.Select(&resources, "SELECT key, created_at FROM resources WHERE user_id = $1", userID)
+	err := s.DB.Select(&resources, "SELECT key, created_at FROM resources WHERE user_id = $1 AND deleted_at IS NULL", userID)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
@@ -67,6 +76,13 @@ func (s resourceService) ListResources(userID int) ([]models.Resource, error) {
 	return resources, nil
 }
 
+// PurgeDeletedResources hard deletes the resources soft deleted longer than
+// retention ago.
+func (s resourceService) PurgeDeletedResources(retention time.Duration) error {
+	_, err := s.DB.Exec("DELETE FROM resources WHERE deleted_at < $1", time.Now().UTC().Add(-retention))
+	return err
+}
+
 func NewResourceService(db *sqlx.DB) ResourceService {
 	return &resourceService{
 		DB: db,
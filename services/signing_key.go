@@ -0,0 +1,288 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+const (
+	// signingKeysRefreshInterval bounds how long a rotation takes to reach
+	// every running instance.
+	signingKeysRefreshInterval = 1 * time.Minute
+	// signingKeysMinReloadInterval stops tokens with made-up kids from
+	// turning every request into a database query.
+	signingKeysMinReloadInterval = 5 * time.Second
+
+	// SigningKeyEncryptionKeySize is the size of the AES-256 key encrypting
+	// the private keys of the signing_keys table.
+	SigningKeyEncryptionKeySize = 32
+)
+
+// pemPrefix starts the private keys stored before they were encrypted.
+const pemPrefix = "-----BEGIN"
+
+// SigningKeyService manages the signing_keys table. Private keys are stored
+// and listed encrypted, only the key store decrypts them.
+type SigningKeyService interface {
+	RotateSigningKey(algorithm string, retireAfter time.Duration) (*models.SigningKey, error)
+	ListSigningKeys() ([]models.SigningKey, error)
+	EncryptSigningKeys() (int, error)
+}
+
+type signingKeyService struct {
+	DB            *sqlx.DB
+	EncryptionKey []byte
+}
+
+// RotateSigningKey creates a new active signing key. The previously active
+// key becomes verify-only so tokens it signed stay valid, and verify-only keys
+// rotated out more than retireAfter ago are retired.
+func (s signingKeyService) RotateSigningKey(algorithm string, retireAfter time.Duration) (*models.SigningKey, error) {
+	privateKey, err := GeneratePrivateKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := EncryptSigningKey(s.EncryptionKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec("UPDATE signing_keys SET status = $1 WHERE status = $2 AND rotated_at < $3", models.SigningKeyStatusRetired, models.SigningKeyStatusVerifyOnly, now.Add(-retireAfter))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("UPDATE signing_keys SET status = $1, rotated_at = $2 WHERE status = $3", models.SigningKeyStatusVerifyOnly, now, models.SigningKeyStatusActive)
+	if err != nil {
+		return nil, err
+	}
+
+	key := models.SigningKey{}
+	err = tx.Get(&key, "INSERT INTO signing_keys (kid, algorithm, private_key, status, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, kid, algorithm, status, created_at", uuid.NewV4().String(), algorithm, encryptedKey, models.SigningKeyStatusActive, now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (s signingKeyService) ListSigningKeys() ([]models.SigningKey, error) {
+	keys := []models.SigningKey{}
+	err := s.DB.Select(&keys, "SELECT id, kid, algorithm, private_key, status, created_at, rotated_at FROM signing_keys WHERE status IN ($1, $2) ORDER BY created_at DESC", models.SigningKeyStatusActive, models.SigningKeyStatusVerifyOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// EncryptSigningKeys encrypts the private keys stored in plain text before
+// they were encrypted, and returns how many it encrypted.
+func (s signingKeyService) EncryptSigningKeys() (int, error) {
+	keys := []models.SigningKey{}
+	err := s.DB.Select(&keys, "SELECT id, private_key FROM signing_keys WHERE private_key LIKE $1", pemPrefix+"%")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		encryptedKey, err := EncryptSigningKey(s.EncryptionKey, []byte(key.PrivateKey))
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = s.DB.Exec("UPDATE signing_keys SET private_key = $1 WHERE id = $2 AND private_key = $3", encryptedKey, key.ID, key.PrivateKey)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(keys), nil
+}
+
+// EncryptSigningKey encrypts a PEM private key with AES-GCM under the
+// SigningKeyEncryptionKeySize bytes encryptionKey, the way the signing_keys
+// table stores it.
+func EncryptSigningKey(encryptionKey []byte, privateKey []byte) (string, error) {
+	if len(encryptionKey) != SigningKeyEncryptionKeySize {
+		return "", fmt.Errorf("signing key encryption key should be %d bytes", SigningKeyEncryptionKeySize)
+	}
+
+	return encrypt(encryptionKey, privateKey)
+}
+
+// NewSigningKeyService returns the signing key service, private keys are
+// encrypted with the AES-256 encryptionKey.
+func NewSigningKeyService(db *sqlx.DB, encryptionKey []byte) SigningKeyService {
+	return &signingKeyService{
+		DB:            db,
+		EncryptionKey: encryptionKey,
+	}
+}
+
+// JWTKeyStore provides the keys used by the JWT token service.
+type JWTKeyStore interface {
+	SigningKey() (*JWTKey, error)
+	VerificationKey(kid string) (*JWTKey, error)
+	VerificationKeys() ([]*JWTKey, error)
+}
+
+type staticJWTKeyStore struct {
+	Key *JWTKey
+}
+
+func (s staticJWTKeyStore) SigningKey() (*JWTKey, error) {
+	return s.Key, nil
+}
+
+func (s staticJWTKeyStore) VerificationKey(kid string) (*JWTKey, error) {
+	if kid != s.Key.ID {
+		return nil, nil
+	}
+
+	return s.Key, nil
+}
+
+func (s staticJWTKeyStore) VerificationKeys() ([]*JWTKey, error) {
+	return []*JWTKey{s.Key}, nil
+}
+
+// NewStaticJWTKeyStore returns a key store holding a single key that both
+// signs and verifies.
+func NewStaticJWTKeyStore(key *JWTKey) JWTKeyStore {
+	return &staticJWTKeyStore{
+		Key: key,
+	}
+}
+
+// signingKeyStore serves keys managed by SigningKeyService from memory and
+// reloads them periodically, so verifying a token does not need a database
+// round trip.
+type signingKeyStore struct {
+	SigningKeyService SigningKeyService
+	EncryptionKey     []byte
+
+	mu       sync.Mutex
+	active   *JWTKey
+	keys     map[string]*JWTKey
+	loadedAt time.Time
+}
+
+func (s *signingKeyStore) SigningKey() (*JWTKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.loadedAt) > signingKeysRefreshInterval {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.active == nil {
+		return nil, fmt.Errorf("no active signing key, rotate the signing key first")
+	}
+
+	return s.active, nil
+}
+
+func (s *signingKeyStore) VerificationKey(kid string) (*JWTKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, known := s.keys[kid]
+	if time.Since(s.loadedAt) > signingKeysRefreshInterval ||
+		(!known && time.Since(s.loadedAt) > signingKeysMinReloadInterval) {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.keys[kid], nil
+}
+
+func (s *signingKeyStore) VerificationKeys() ([]*JWTKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.loadedAt) > signingKeysRefreshInterval {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := []*JWTKey{}
+	if s.active != nil {
+		keys = append(keys, s.active)
+	}
+	for _, key := range s.keys {
+		if key != s.active {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *signingKeyStore) load() error {
+	signingKeys, err := s.SigningKeyService.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	var active *JWTKey
+	keys := map[string]*JWTKey{}
+	for _, signingKey := range signingKeys {
+		if strings.HasPrefix(signingKey.PrivateKey, pemPrefix) {
+			return fmt.Errorf("signing key '%s' is not encrypted, run rotatesigningkey -encrypt-existing", signingKey.KeyID)
+		}
+
+		privateKey, err := decrypt(s.EncryptionKey, signingKey.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt signing key '%s', err: %s", signingKey.KeyID, err)
+		}
+
+		key, err := NewJWTKey(signingKey.KeyID, signingKey.Algorithm, privateKey)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key '%s', err: %s", signingKey.KeyID, err)
+		}
+
+		keys[key.ID] = key
+		if signingKey.Status == models.SigningKeyStatusActive {
+			active = key
+		}
+	}
+
+	s.active = active
+	s.keys = keys
+	s.loadedAt = time.Now()
+	return nil
+}
+
+// NewSigningKeyStore returns a key store backed by the signing_keys table,
+// decrypting the private keys with the AES-256 encryptionKey.
+func NewSigningKeyStore(signingKeyService SigningKeyService, encryptionKey []byte) JWTKeyStore {
+	return &signingKeyStore{
+		SigningKeyService: signingKeyService,
+		EncryptionKey:     encryptionKey,
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+func TestSigningKeyServiceEncryptsPrivateKeys(t *testing.T) {
+	encryptionKey := []byte("0123456789abcdef0123456789abcdef")
+	plaintextKey, err := GeneratePrivateKey(JWTAlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedKeys := map[int64]string{1: string(plaintextKey)}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, "INSERT INTO signing_keys "):
+			storedKeys[int64(len(storedKeys)+1)] = args[2].(string)
+			return []string{"id", "kid", "algorithm", "status"}, [][]driver.Value{{int64(len(storedKeys)), args[0], args[1], args[3]}}
+		case strings.HasPrefix(query, "SELECT id, private_key FROM signing_keys "):
+			rows := [][]driver.Value{}
+			for id, key := range storedKeys {
+				if strings.HasPrefix(key, strings.TrimSuffix(args[0].(string), "%")) {
+					rows = append(rows, []driver.Value{id, key})
+				}
+			}
+			return []string{"id", "private_key"}, rows
+		case strings.HasPrefix(query, "UPDATE signing_keys SET private_key "):
+			if storedKeys[args[1].(int64)] == args[2] {
+				storedKeys[args[1].(int64)] = args[0].(string)
+				return nil, [][]driver.Value{{}}
+			}
+		}
+		return nil, nil
+	})
+	signingKeyService := NewSigningKeyService(db, encryptionKey)
+
+	// Should store new keys encrypted
+	key, err := signingKeyService.RotateSigningKey(JWTAlgorithmRS256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Status != models.SigningKeyStatusActive {
+		t.Errorf("rotated key is %s, want active", key.Status)
+	}
+	privateKey, err := decrypt(encryptionKey, storedKeys[2])
+	if err != nil || !strings.HasPrefix(string(privateKey), pemPrefix) {
+		t.Errorf("new key not stored encrypted: %s, err: %v", storedKeys[2], err)
+	}
+
+	// Should encrypt the keys stored in plain text only
+	count, err := signingKeyService.EncryptSigningKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("encrypted %d keys, want 1", count)
+	}
+	privateKey, err = decrypt(encryptionKey, storedKeys[1])
+	if err != nil || string(privateKey) != string(plaintextKey) {
+		t.Errorf("plain text key not encrypted: %s, err: %v", storedKeys[1], err)
+	}
+
+	// Should refuse to store keys without a valid encryption key
+	_, err = NewSigningKeyService(db, nil).RotateSigningKey(JWTAlgorithmRS256, 0)
+	if err == nil {
+		t.Errorf("key stored without an encryption key")
+	}
+}
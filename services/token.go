@@ -1,7 +1,11 @@
 package services
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"time"
@@ -12,24 +16,58 @@ import (
 )
 
 type TokenService interface {
-	CreateToken(expiresIn time.Duration, scope []string, userID int) (string, error)
+	// CreateToken issues an access token. family is the one of the refresh
+	// token issued with it, so that revoking the refresh token revokes the
+	// access token as well, empty without a refresh token.
+	CreateToken(expiresIn time.Duration, scope []string, userID int, family string) (string, error)
+	// CreateRefreshToken issues a refresh token starting a new family, Token
+	// holds the plain token.
+	CreateRefreshToken(expiresIn time.Duration, scope []string, userID int) (*models.RefreshToken, error)
+	CreatePersonalAccessToken(name string, expiresIn time.Duration, scope []string, userID int) (*models.PersonalAccessToken, error)
+	ListPersonalAccessTokens(userID int) ([]models.PersonalAccessToken, error)
+	RevokePersonalAccessToken(userID int, tokenID int) error
+	RotateRefreshToken(refreshToken string, expiresIn time.Duration, scope []string) (*models.RefreshToken, string, error)
+	CreateAuthorizationCode(expiresIn time.Duration, clientID string, redirectURI string, scope []string, codeChallenge string, userID int) (string, error)
+	ExchangeAuthorizationCode(code string, clientID string, redirectURI string, codeVerifier string) (*models.AuthorizationCode, error)
+	RevokeToken(token string) error
+	RevokeUserTokens(userID int) error
 	CleanExpiredTokens() error
-	AuthenticateToken(token string, path string) (*models.Token, error)
+	// AuthenticateToken returns the token if it is valid, belongs to an
+	// active user and grants scope, an empty scope skips the scope check.
+	AuthenticateToken(token string, scope string) (*models.Token, error)
+	JSONWebKeys() ([]models.JSONWebKey, error)
 }
 
+// lastUsedAtResolution limits how often using a personal access token writes
+// its last_used_at.
+const lastUsedAtResolution = 1 * time.Minute
+
 type TokenAuthenticationError struct{}
 
 func (e TokenAuthenticationError) Error() string {
 	return fmt.Sprint("token invalid")
 }
 
+type RefreshTokenInvalidError struct{}
+
+func (e RefreshTokenInvalidError) Error() string {
+	return fmt.Sprint("refresh token invalid")
+}
+
+type AuthorizationCodeInvalidError struct{}
+
+func (e AuthorizationCodeInvalidError) Error() string {
+	return fmt.Sprint("authorization code invalid")
+}
+
 type tokenService struct {
 	DB *sqlx.DB
 }
 
-func (s tokenService) CreateToken(expiresIn time.Duration, scope []string, userID int) (string, error) {
+func (s tokenService) CreateToken(expiresIn time.Duration, scope []string, userID int, family string) (string, error) {
 	token := uuid.NewV4()
-	_, err := s.DB.Exec("INSERT INTO access_tokens (token, expires, scope, user_id) VALUES ($1, $2, $3, $4)", token.String(), time.Now().UTC().Add(expiresIn), strings.Join(scope, " "), userID)
+	now := time.Now().UTC()
+	_, err := s.DB.Exec("INSERT INTO access_tokens (token, expires, scope, user_id, created_at, family) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))", hashToken(token.String()), now.Add(expiresIn), strings.Join(scope, " "), userID, now, family)
 	if err != nil {
 		return "", err
 	}
@@ -37,29 +75,300 @@ func (s tokenService) CreateToken(expiresIn time.Duration, scope []string, userI
 	return token.String(), nil
 }
 
-func (s tokenService) AuthenticateToken(tokenString string, path string) (*models.Token, error) {
+func (s tokenService) CreatePersonalAccessToken(name string, expiresIn time.Duration, scope []string, userID int) (*models.PersonalAccessToken, error) {
+	name = strings.TrimSpace(name)
+	err := models.ValidatePersonalAccessToken(name, expiresIn)
+	if err != nil {
+		return nil, err
+	}
+
+	token := uuid.NewV4()
+	personalAccessToken := models.PersonalAccessToken{
+		Name:      name,
+		Token:     token.String(),
+		Scope:     strings.Join(scope, " "),
+		CreatedAt: time.Now().UTC(),
+	}
+	personalAccessToken.Expires = personalAccessToken.CreatedAt.Add(expiresIn)
+
+	err = s.DB.Get(&personalAccessToken.ID, "INSERT INTO access_tokens (token, expires, scope, user_id, created_at, name) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id", hashToken(token.String()), personalAccessToken.Expires, personalAccessToken.Scope, userID, personalAccessToken.CreatedAt, personalAccessToken.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &personalAccessToken, nil
+}
+
+func (s tokenService) ListPersonalAccessTokens(userID int) ([]models.PersonalAccessToken, error) {
+	tokens := []models.PersonalAccessToken{}
+	err := s.DB.Select(&tokens, "SELECT id, name, scope, expires, created_at, last_used_at FROM access_tokens WHERE user_id = $1 AND name IS NOT NULL AND expires > NOW() ORDER BY created_at", userID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (s tokenService) RevokePersonalAccessToken(userID int, tokenID int) error {
 	token := models.Token{}
-	err := s.DB.Get(&token, "SELECT token, expires, scope, user_id FROM access_tokens WHERE token = $1 AND expires > NOW()", tokenString)
+	err := s.DB.Get(&token, "SELECT id FROM access_tokens WHERE id = $1 AND user_id = $2 AND name IS NOT NULL", tokenID, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec("DELETE FROM access_tokens WHERE id = $1", token.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s tokenService) CreateRefreshToken(expiresIn time.Duration, scope []string, userID int) (*models.RefreshToken, error) {
+	token := models.RefreshToken{
+		Family:  uuid.NewV4().String(),
+		Expires: time.Now().UTC().Add(expiresIn),
+		Scope:   strings.Join(scope, " "),
+		UserID:  userID,
+	}
+
+	var err error
+	token.Token, err = insertRefreshToken(s.DB, token.Family, expiresIn, token.Scope, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// RotateRefreshToken marks the given refresh token as used and issues its
+// successor in the same family. Presenting a token that was already used
+// revokes every refresh token in its family, since either the legitimate
+// client or an attacker is replaying a stolen token. A requested scope
+// outside of the refresh token scope, or a suspended user, fails without
+// consuming the token so that it still works once the user is reactivated.
+func (s tokenService) RotateRefreshToken(tokenString string, expiresIn time.Duration, scope []string) (*models.RefreshToken, string, error) {
+	tx, err := s.DB.Beginx()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	token := models.RefreshToken{}
+	err = tx.Get(&token, "SELECT token, family, expires, scope, used, user_id FROM refresh_tokens WHERE token = $1 AND expires > NOW() FOR UPDATE", hashToken(tokenString))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", err
+	}
+	if err == sql.ErrNoRows {
+		return nil, "", RefreshTokenInvalidError{}
+	}
+
+	if token.Used {
+		_, err = tx.Exec("DELETE FROM refresh_tokens WHERE family = $1", token.Family)
+		if err != nil {
+			return nil, "", err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, "", err
+		}
+
+		return nil, "", RefreshTokenInvalidError{}
+	}
+
+	_, err = models.ParseScope(token.Scope).Narrow(scope)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var status string
+	err = tx.Get(&status, "SELECT status FROM users WHERE id = $1 AND deleted_at IS NULL", token.UserID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", err
+	}
+	if err == sql.ErrNoRows {
+		return nil, "", RefreshTokenInvalidError{}
+	}
+	if status == models.UserStatusSuspended {
+		return nil, "", UserSuspendedError{}
+	}
+
+	_, err = tx.Exec("UPDATE refresh_tokens SET used = TRUE WHERE token = $1", token.Token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	newToken, err := insertRefreshToken(tx, token.Family, expiresIn, token.Scope, token.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &token, newToken, nil
+}
+
+func (s tokenService) CreateAuthorizationCode(expiresIn time.Duration, clientID string, redirectURI string, scope []string, codeChallenge string, userID int) (string, error) {
+	code := uuid.NewV4()
+	_, err := s.DB.Exec("INSERT INTO authorization_codes (code, client_id, redirect_uri, scope, code_challenge, expires, user_id) VALUES ($1, $2, $3, $4, $5, $6, $7)", hashToken(code.String()), clientID, redirectURI, strings.Join(scope, " "), codeChallenge, time.Now().UTC().Add(expiresIn), userID)
+	if err != nil {
+		return "", err
+	}
+
+	return code.String(), nil
+}
+
+// ExchangeAuthorizationCode consumes the code and returns it if it was issued
+// to the client for the redirect uri and the code verifier matches its code
+// challenge. The code is consumed even if the exchange fails, so a code can
+// never be tried twice.
+func (s tokenService) ExchangeAuthorizationCode(codeString string, clientID string, redirectURI string, codeVerifier string) (*models.AuthorizationCode, error) {
+	code := models.AuthorizationCode{}
+	err := s.DB.Get(&code, "DELETE FROM authorization_codes WHERE code = $1 RETURNING code, client_id, redirect_uri, scope, code_challenge, expires, user_id", hashToken(codeString))
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
+	if err == sql.ErrNoRows {
+		return nil, AuthorizationCodeInvalidError{}
+	}
 
-	if !strings.Contains(token.Scope, path) {
+	if code.Expires.Before(time.Now().UTC()) ||
+		code.ClientID != clientID ||
+		code.RedirectURI != redirectURI ||
+		!verifyCodeChallenge(code.CodeChallenge, codeVerifier) {
+		return nil, AuthorizationCodeInvalidError{}
+	}
+	code.Code = codeString
+
+	return &code, nil
+}
+
+func (s tokenService) AuthenticateToken(tokenString string, scope string) (*models.Token, error) {
+	token := models.Token{}
+	err := s.DB.Get(&token, "SELECT access_tokens.id, token, expires, scope, user_id, access_tokens.created_at, name, last_used_at FROM access_tokens JOIN users ON users.id = access_tokens.user_id WHERE token = $1 AND expires > NOW() AND users.status = $2 AND users.deleted_at IS NULL", hashToken(tokenString), models.UserStatusActive)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == sql.ErrNoRows {
 		return nil, TokenAuthenticationError{}
 	}
+	token.Token = tokenString
+
+	if scope != "" && !models.ParseScope(token.Scope).Contains(scope) {
+		return nil, TokenAuthenticationError{}
+	}
+
+	if token.Name != nil && (token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > lastUsedAtResolution) {
+		// last_used_at is informational, failing to record it must not fail
+		// the authentication.
+		s.DB.Exec("UPDATE access_tokens SET last_used_at = $1 WHERE id = $2", time.Now().UTC(), token.ID)
+	}
 
 	return &token, nil
 }
 
+// RevokeToken removes the given access token or refresh token. Revoking a
+// refresh token revokes the rest of its family as well. Unknown tokens are
+// ignored.
+func (s tokenService) RevokeToken(tokenString string) error {
+	_, err := s.revokeToken(tokenString)
+	return err
+}
+
+// revokeToken removes the access token, or the refresh token with its whole
+// family and the access tokens issued with them (RFC 7009 section 2.1), and
+// returns the revoked families.
+func (s tokenService) revokeToken(tokenString string) ([]string, error) {
+	families := []string{}
+	err := s.DB.Select(&families, "WITH families AS (DELETE FROM refresh_tokens WHERE family IN (SELECT family FROM refresh_tokens WHERE token = $1) RETURNING family), revoked_access_tokens AS (DELETE FROM access_tokens WHERE token = $1 OR family IN (SELECT family FROM families)) SELECT DISTINCT family FROM families", hashToken(tokenString))
+	if err != nil {
+		return nil, err
+	}
+
+	return families, nil
+}
+
+func (s tokenService) RevokeUserTokens(userID int) error {
+	_, err := s.DB.Exec("DELETE FROM access_tokens WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec("DELETE FROM refresh_tokens WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (s tokenService) CleanExpiredTokens() error {
 	_, err := s.DB.Exec("DELETE FROM access_tokens WHERE expires < NOW()")
 	if err != nil {
 		return err
 	}
 
+	_, err = s.DB.Exec("DELETE FROM refresh_tokens WHERE expires < NOW()")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec("DELETE FROM authorization_codes WHERE expires < NOW()")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec("DELETE FROM revoked_jwts WHERE expires < NOW()")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec("DELETE FROM revoked_token_families WHERE expires < NOW()")
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// JSONWebKeys returns no keys, opaque access tokens are not signed.
+func (s tokenService) JSONWebKeys() ([]models.JSONWebKey, error) {
+	return []models.JSONWebKey{}, nil
+}
+
+func insertRefreshToken(db sqlx.Execer, family string, expiresIn time.Duration, scope string, userID int) (string, error) {
+	token := uuid.NewV4()
+	_, err := db.Exec("INSERT INTO refresh_tokens (token, family, expires, scope, user_id) VALUES ($1, $2, $3, $4, $5)", hashToken(token.String()), family, time.Now().UTC().Add(expiresIn), scope, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return token.String(), nil
+}
+
+// hashToken returns the form tokens are stored in, so that leaking the
+// database does not leak usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against its S256 code
+// challenge. Code verifiers are 43 to 128 characters long.
+func verifyCodeChallenge(codeChallenge string, codeVerifier string) bool {
+	if len(codeVerifier) < 43 || len(codeVerifier) > 128 {
+		return false
+	}
+
+	hashed := sha256.Sum256([]byte(codeVerifier))
+	expected := base64.RawURLEncoding.EncodeToString(hashed[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(codeChallenge)) == 1
+}
+
 func NewTokenService(db *sqlx.DB) TokenService {
 	return &tokenService{
 		DB: db,
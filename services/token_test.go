@@ -0,0 +1,169 @@
+package services
+
+import (
+	"database/sql/driver"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+func TestHashToken(t *testing.T) {
+	// Should be the lowercase hex SHA-256 of the UTF-8 token, which is what
+	// encode(sha256(convert_to(token, 'UTF8')), 'hex') returns in postgres
+	if hash := hashToken("abc"); hash != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("unexpected hash of abc: %s", hash)
+	}
+	if hash := hashToken(`to\ken`); hash != "b82436d949cc8362ae0dd37c9e60f446e10fced29bf374b468bd1504984bb258" {
+		t.Errorf("unexpected hash of a token with a backslash: %s", hash)
+	}
+
+	// Should hash the existing tokens of the migration the same way
+	migration, err := ioutil.ReadFile("../db/migrations/20261018120000_hash_tokens.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"access_tokens", "refresh_tokens"} {
+		statement := "UPDATE " + table + " SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');"
+		if !strings.Contains(string(migration), statement) {
+			t.Errorf("migration does not hash %s with: %s", table, statement)
+		}
+	}
+}
+
+func TestTokenServiceStoresHashes(t *testing.T) {
+	storedTokens := map[string][]string{}
+	queriedArgs := []driver.Value{}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		queriedArgs = append(queriedArgs, args...)
+
+		switch {
+		case strings.HasPrefix(query, "INSERT INTO access_tokens "):
+			storedTokens["access_tokens"] = append(storedTokens["access_tokens"], args[0].(string))
+		case strings.HasPrefix(query, "INSERT INTO refresh_tokens "):
+			storedTokens["refresh_tokens"] = append(storedTokens["refresh_tokens"], args[0].(string))
+		case strings.HasPrefix(query, "SELECT access_tokens.id, token, "):
+			for _, token := range storedTokens["access_tokens"] {
+				if args[0] == token {
+					return []string{"id", "token", "expires", "scope", "user_id", "created_at", "name", "last_used_at"},
+						[][]driver.Value{{int64(1), token, time.Now().Add(time.Hour), "resources:read", int64(1), time.Now(), nil, nil}}
+				}
+			}
+		case strings.HasPrefix(query, "SELECT token, family, "):
+			for _, token := range storedTokens["refresh_tokens"] {
+				if args[0] == token {
+					return []string{"token", "family", "expires", "scope", "used", "user_id"},
+						[][]driver.Value{{token, "family", time.Now().Add(time.Hour), "resources:read", false, int64(1)}}
+				}
+			}
+		case strings.HasPrefix(query, "SELECT status FROM users "):
+			return []string{"status"}, [][]driver.Value{{models.UserStatusActive}}
+		}
+		return nil, nil
+	})
+	tokenService := NewTokenService(db)
+
+	// Should store the hash of an access token
+	accessToken, err := tokenService.CreateToken(time.Hour, []string{"resources:read"}, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(storedTokens["access_tokens"]) != 1 || storedTokens["access_tokens"][0] != hashToken(accessToken) {
+		t.Fatalf("access token not stored as its hash: %v", storedTokens["access_tokens"])
+	}
+
+	// Should authenticate the access token by its hash
+	token, err := tokenService.AuthenticateToken(accessToken, "resources:read")
+	if err != nil {
+		t.Fatalf("access token not authenticated: %v", err)
+	}
+	if token.Token != accessToken {
+		t.Errorf("authenticated token is %s, want the access token %s", token.Token, accessToken)
+	}
+
+	// Should not authenticate with the stored hash
+	_, err = tokenService.AuthenticateToken(hashToken(accessToken), "resources:read")
+	if _, ok := err.(TokenAuthenticationError); !ok {
+		t.Errorf("stored hash authenticated as an access token: %v", err)
+	}
+
+	// Should store the hash of a refresh token
+	createdRefreshToken, err := tokenService.CreateRefreshToken(time.Hour, []string{"resources:read"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshToken := createdRefreshToken.Token
+	if len(storedTokens["refresh_tokens"]) != 1 || storedTokens["refresh_tokens"][0] != hashToken(refreshToken) {
+		t.Fatalf("refresh token not stored as its hash: %v", storedTokens["refresh_tokens"])
+	}
+
+	// Should not rotate the stored hash
+	_, _, err = tokenService.RotateRefreshToken(hashToken(refreshToken), time.Hour, nil)
+	if _, ok := err.(RefreshTokenInvalidError); !ok {
+		t.Errorf("stored hash rotated as a refresh token: %v", err)
+	}
+
+	// Should rotate the refresh token by its hash, and store the hash of its
+	// successor
+	_, newRefreshToken, err := tokenService.RotateRefreshToken(refreshToken, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("refresh token not rotated: %v", err)
+	}
+	if len(storedTokens["refresh_tokens"]) != 2 || storedTokens["refresh_tokens"][1] != hashToken(newRefreshToken) {
+		t.Errorf("new refresh token not stored as its hash: %v", storedTokens["refresh_tokens"])
+	}
+
+	// Should never send a token to the database
+	for _, arg := range queriedArgs {
+		if arg == accessToken || arg == refreshToken || arg == newRefreshToken {
+			t.Errorf("token sent to the database: %v", arg)
+		}
+	}
+}
+
+func TestRotateRefreshTokenUserStatus(t *testing.T) {
+	statuses := map[int64]string{1: models.UserStatusActive, 2: models.UserStatusSuspended}
+	used := map[string]bool{}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, "SELECT token, family, "):
+			userID := map[string]int64{hashToken("activetoken"): 1, hashToken("suspendedtoken"): 2, hashToken("deletedtoken"): 3}[args[0].(string)]
+			return []string{"token", "family", "expires", "scope", "used", "user_id"},
+				[][]driver.Value{{args[0], "family", time.Now().Add(time.Hour), "resources:read", used[args[0].(string)], userID}}
+		case strings.HasPrefix(query, "SELECT status FROM users "):
+			if status, ok := statuses[args[0].(int64)]; ok {
+				return []string{"status"}, [][]driver.Value{{status}}
+			}
+		case strings.HasPrefix(query, "UPDATE refresh_tokens SET used = TRUE "):
+			used[args[0].(string)] = true
+		}
+		return nil, nil
+	})
+	tokenService := NewTokenService(db)
+
+	// Should not consume the refresh token of a suspended user
+	_, _, err := tokenService.RotateRefreshToken("suspendedtoken", time.Hour, nil)
+	if _, ok := err.(UserSuspendedError); !ok {
+		t.Errorf("refresh token of a suspended user rotated: %v", err)
+	}
+	if used[hashToken("suspendedtoken")] {
+		t.Errorf("refresh token of a suspended user consumed")
+	}
+
+	// Should not rotate the refresh token of a deleted user
+	_, _, err = tokenService.RotateRefreshToken("deletedtoken", time.Hour, nil)
+	if _, ok := err.(RefreshTokenInvalidError); !ok {
+		t.Errorf("refresh token of a deleted user rotated: %v", err)
+	}
+
+	// Should rotate the refresh token of an active user
+	_, _, err = tokenService.RotateRefreshToken("activetoken", time.Hour, nil)
+	if err != nil {
+		t.Errorf("refresh token of an active user not rotated: %v", err)
+	}
+	if !used[hashToken("activetoken")] {
+		t.Errorf("refresh token of an active user not consumed")
+	}
+}
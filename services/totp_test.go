@@ -0,0 +1,112 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 test vectors for SHA1, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	// Should compute the codes of the RFC test vectors
+	for _, vector := range vectors {
+		code := totpCode(secret, totpStep(time.Unix(vector.unix, 0)))
+		if code != vector.code {
+			t.Errorf("totpCode returned wrong code at %d: got %v want %v", vector.unix, code, vector.code)
+		}
+	}
+
+	now := time.Unix(1111111111, 0)
+
+	// Should match the code of the current step
+	step, ok := matchTOTP(secret, "050471", now)
+	if !ok || step != totpStep(now) {
+		t.Errorf("matchTOTP did not match the current code: got %v, %v", step, ok)
+	}
+
+	// Should match the code of the previous step to allow for clock drift
+	previousCode := totpCode(secret, totpStep(now)-1)
+	step, ok = matchTOTP(secret, previousCode, now)
+	if !ok || step != totpStep(now)-1 {
+		t.Errorf("matchTOTP did not match the previous code: got %v, %v", step, ok)
+	}
+
+	// Should not match codes outside of the allowed clock drift
+	if _, ok := matchTOTP(secret, totpCode(secret, totpStep(now)+2), now); ok {
+		t.Errorf("matchTOTP matched a code two steps ahead")
+	}
+
+	// Should not match codes of the wrong length
+	if _, ok := matchTOTP(secret, "50471", now); ok {
+		t.Errorf("matchTOTP matched a code of the wrong length")
+	}
+}
+
+func TestMFASecretEncryption(t *testing.T) {
+	service := mfaService{EncryptionKey: []byte("0123456789abcdef0123456789abcdef")}
+
+	// Should decrypt encrypted secrets
+	encrypted, err := service.encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := service.decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "secret" {
+		t.Errorf("decrypt returned wrong secret: got %v want %v", string(decrypted), "secret")
+	}
+
+	// Should not decrypt secrets encrypted with another key
+	otherService := mfaService{EncryptionKey: []byte("fedcba9876543210fedcba9876543210")}
+	if _, err := otherService.decrypt(encrypted); err == nil {
+		t.Errorf("decrypt did not fail with another key")
+	}
+
+	// Should fail with MFANotConfiguredError without key
+	unconfiguredService := mfaService{}
+	if _, err := unconfiguredService.encrypt([]byte("secret")); err != (MFANotConfiguredError{}) {
+		t.Errorf("encrypt returned wrong error without key: got %v", err)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	recoveryCodes, hashedRecoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Should generate ten formatted recovery codes
+	if len(recoveryCodes) != 10 || len(hashedRecoveryCodes) != 10 {
+		t.Fatalf("generateRecoveryCodes returned wrong number of codes: got %v and %v hashes", len(recoveryCodes), len(hashedRecoveryCodes))
+	}
+	format := regexp.MustCompile(`^[a-z2-7]{8}-[a-z2-7]{8}$`)
+	for _, recoveryCode := range recoveryCodes {
+		if !format.MatchString(recoveryCode) {
+			t.Errorf("generateRecoveryCodes returned malformed code: %v", recoveryCode)
+		}
+	}
+
+	// Should hash recovery codes however the user types them
+	code := recoveryCodes[0]
+	for _, typed := range []string{code, code[:8] + code[9:], " " + code + " ", strings.ToUpper(code)} {
+		if hashRecoveryCode(typed) != hashedRecoveryCodes[0] {
+			t.Errorf("hashRecoveryCode returned wrong hash for %v", typed)
+		}
+	}
+}
@@ -4,62 +4,156 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	"golang.org/x/crypto/bcrypt"
+	uuid "github.com/satori/go.uuid"
 
 	"github.com/moonkeat/chainstack/models"
 )
 
 const UserQuotaUndefined = -1
 
+// DefaultSoftDeleteRetention is how long deleted users and resources can be
+// restored before they are purged.
+const DefaultSoftDeleteRetention = 30 * 24 * time.Hour
+
+// userColumns are the columns of models.User except the password. The failed
+// logins and lock of a user are the ones of their email, and the lock is only
+// returned while it lasts.
+var userColumns = fmt.Sprintf("id, email, admin, COALESCE(quota, %d) as quota, token_expires_in, COALESCE((SELECT failed_login_attempts FROM email_login_attempts WHERE email_login_attempts.email = lower(users.email)), 0) AS failed_login_attempts, (SELECT locked_until FROM email_login_attempts WHERE email_login_attempts.email = lower(users.email) AND locked_until > NOW()) AS locked_until, EXISTS (SELECT 1 FROM user_mfa WHERE user_mfa.user_id = users.id AND confirmed) AS mfa_enabled, status, created_at", UserQuotaUndefined)
+
+// LoginLockout throttles password guessing. Every failed login locks the email
+// and the client ip for a delay doubling from one second, and MaxAttempts
+// failures in a row for an email, or IPMaxAttempts for an ip, lock it for
+// Duration. Failures are forgotten Duration after the last lock ended.
+type LoginLockout struct {
+	MaxAttempts   int
+	IPMaxAttempts int
+	Duration      time.Duration
+}
+
+var DefaultLoginLockout = LoginLockout{
+	MaxAttempts:   5,
+	IPMaxAttempts: 20,
+	Duration:      15 * time.Minute,
+}
+
+// delay returns how long the nth failed login in a row locks out.
+func (l LoginLockout) delay(attempts int, maxAttempts int) time.Duration {
+	if attempts >= maxAttempts || attempts > 30 {
+		return l.Duration
+	}
+
+	delay := time.Second << uint(attempts-1)
+	if delay > l.Duration {
+		return l.Duration
+	}
+
+	return delay
+}
+
+type UserLockedError struct {
+	LockedUntil time.Time
+}
+
+func (e UserLockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, locked until %s", e.LockedUntil.Format(time.RFC3339))
+}
+
+// UserSuspendedError is returned when a suspended user logs in with the
+// correct password.
+type UserSuspendedError struct{}
+
+func (e UserSuspendedError) Error() string {
+	return fmt.Sprint("user is suspended")
+}
+
+// UserPasswordIncorrectError is returned when the current password of a
+// password change, or the password confirming a change, does not match.
+type UserPasswordIncorrectError struct{}
+
+func (e UserPasswordIncorrectError) Error() string {
+	return fmt.Sprint("current password is incorrect")
+}
+
+// UserEmailExistsError is returned when an update gives a user the email of
+// another user.
+type UserEmailExistsError struct{}
+
+func (e UserEmailExistsError) Error() string {
+	return fmt.Sprint("user with email already exists")
+}
+
+type PasswordResetTokenInvalidError struct{}
+
+func (e PasswordResetTokenInvalidError) Error() string {
+	return fmt.Sprint("password reset token invalid")
+}
+
 type UserService interface {
-	CreateUser(email string, password string, isAdmin bool, quota *int) (*models.User, error)
+	CreateUser(email string, password string, isAdmin bool, quota *int, tokenExpiresIn *int) (*models.User, error)
 	GetUser(userID int) (*models.User, error)
-	UpdateUserQuota(userID int, quota *int) (*models.User, error)
+	UpdateUser(userID int, update models.UserUpdate) (*models.User, error)
+	UnlockUser(userID int) (*models.User, error)
+	ChangeUserPassword(userID int, currentPassword string, newPassword string, ip string) error
+	VerifyUserPassword(userID int, password string, ip string) error
+	CreatePasswordResetToken(userID int, expiresIn time.Duration) (*models.PasswordResetToken, error)
+	ResetUserPassword(token string, newPassword string) (*models.User, error)
 	DeleteUser(userID int) error
-	ListUsers() ([]models.User, error)
-	AuthenticateUser(email string, password string) (*models.User, error)
+	RestoreUser(userID int) (*models.User, error)
+	ListUsers(options models.UserListOptions) ([]models.User, *models.UserCursor, error)
+	AuthenticateUser(email string, password string, ip string) (*models.User, error)
+	CheckLoginLockout(email string, ip string) error
+	RecordFailedLogin(email string, ip string) error
+	ResetFailedLogins(email string) error
+	CleanExpiredLoginAttempts() error
+	CleanExpiredPasswordResetTokens() error
+	PurgeDeletedUsers(retention time.Duration) error
 }
 
 type userService struct {
-	DB *sqlx.DB
+	DB                *sqlx.DB
+	Lockout           LoginLockout
+	PasswordPolicy    models.PasswordPolicy
+	PasswordHasher    PasswordHasher
+	DummyPasswordHash string
 }
 
-func (s userService) CreateUser(email string, password string, isAdmin bool, quota *int) (*models.User, error) {
+func (s userService) CreateUser(email string, password string, isAdmin bool, quota *int, tokenExpiresIn *int) (*models.User, error) {
 	email = strings.TrimSpace(email)
-	err := models.ValidateUser(email, password)
+	err := models.ValidateUser(&models.User{
+		Email:          email,
+		Quota:          quota,
+		TokenExpiresIn: tokenExpiresIn,
+		Status:         models.UserStatusActive,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	err = s.PasswordPolicy.ValidatePassword(email, password)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = s.DB.Exec("INSERT INTO users (email, password, admin, quota) VALUES (lower($1), $2, $3, $4)", email, passwordHash, isAdmin, quota)
+	passwordHash, err := s.PasswordHasher.Hash(password)
 	if err != nil {
-		if strings.Contains(err.Error(), "users_unique_lower_email_idx") {
-			return nil, models.UserValidationError{
-				Field:  "email",
-				Reason: fmt.Sprintf("user with email already exists"),
-			}
-		}
 		return nil, err
 	}
 
-	user, err := s.AuthenticateUser(email, password)
+	var userID int
+	err = s.DB.Get(&userID, "INSERT INTO users (email, password, admin, quota, token_expires_in) VALUES (lower($1), $2, $3, $4, $5) RETURNING id", email, passwordHash, isAdmin, quota, tokenExpiresIn)
 	if err != nil {
-		return nil, err
+		return nil, emailExistsError(err)
 	}
 
-	return user, nil
+	return s.GetUser(userID)
 }
 
 func (s userService) GetUser(userID int) (*models.User, error) {
 	user := models.User{}
-	err := s.DB.Get(&user, fmt.Sprintf("SELECT id, email, admin, COALESCE(quota, %d) as quota FROM users WHERE id = $1", UserQuotaUndefined), userID)
+	err := s.DB.Get(&user, "SELECT "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -67,63 +161,489 @@ func (s userService) GetUser(userID int) (*models.User, error) {
 	return &user, nil
 }
 
-func (s userService) UpdateUserQuota(userID int, quota *int) (*models.User, error) {
-	_, err := s.DB.Exec("UPDATE users SET quota = $1 WHERE id = $2", quota, userID)
+// UpdateUser applies the update to the user and validates the result as a
+// whole. The user is locked from the read to the write, so that concurrent
+// updates of other fields are not undone.
+func (s userService) UpdateUser(userID int, update models.UserUpdate) (*models.User, error) {
+	tx, err := s.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user := models.User{}
+	err = tx.Get(&user, "SELECT id, email, admin, quota, token_expires_in, status FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", userID)
 	if err != nil {
 		return nil, err
 	}
 
+	update.Apply(&user)
+	user.Email = strings.TrimSpace(user.Email)
+	err = models.ValidateUser(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("UPDATE users SET email = lower($1), admin = $2, quota = $3, token_expires_in = $4, status = $5 WHERE id = $6", user.Email, user.Admin, user.Quota, user.TokenExpiresIn, user.Status, user.ID)
+	if err != nil {
+		if isEmailExistsError(err) {
+			return nil, UserEmailExistsError{}
+		}
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetUser(userID)
+}
+
+func (s userService) UnlockUser(userID int) (*models.User, error) {
 	user, err := s.GetUser(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	return user, nil
+	err = s.ResetFailedLogins(user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetUser(userID)
 }
 
-func (s userService) DeleteUser(userID int) error {
+// ChangeUserPassword sets a new password if the current password matches.
+// Wrong current passwords count as failed logins, so that a stolen access
+// token can not be used to guess the password.
+func (s userService) ChangeUserPassword(userID int, currentPassword string, newPassword string, ip string) error {
 	user := models.User{}
-	err := s.DB.Get(&user, "SELECT id FROM users WHERE id = $1", userID)
+	err := s.DB.Get(&user, "SELECT password, "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL", userID)
 	if err != nil {
 		return err
 	}
 
-	_, err = s.DB.Query("DELETE FROM users WHERE id = $1", user.ID)
+	err = s.PasswordPolicy.ValidatePassword(user.Email, newPassword)
 	if err != nil {
 		return err
 	}
 
+	err = s.checkUserPassword(user, currentPassword, ip)
+	if err != nil {
+		return err
+	}
+
+	return s.setUserPassword(user.ID, newPassword)
+}
+
+// VerifyUserPassword checks the password of a signed in user confirming a
+// sensitive change, with the same lockout as logins.
+func (s userService) VerifyUserPassword(userID int, password string, ip string) error {
+	user := models.User{}
+	err := s.DB.Get(&user, "SELECT password, "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL", userID)
+	if err != nil {
+		return err
+	}
+
+	return s.checkUserPassword(user, password, ip)
+}
+
+// checkUserPassword returns UserPasswordIncorrectError if the password does
+// not match the one of the user, and counts it as a failed login.
+func (s userService) checkUserPassword(user models.User, password string, ip string) error {
+	err := s.CheckLoginLockout(user.Email, ip)
+	if err != nil {
+		return err
+	}
+
+	if !checkPassword(user.Password, s.DummyPasswordHash, password) {
+		err = s.RecordFailedLogin(user.Email, ip)
+		if err != nil {
+			return err
+		}
+
+		return UserPasswordIncorrectError{}
+	}
+
 	return nil
 }
 
-func (s userService) ListUsers() ([]models.User, error) {
-	users := []models.User{}
-	err := s.DB.Select(&users, fmt.Sprintf("SELECT id, email, admin, COALESCE(quota, %d) as quota FROM users", UserQuotaUndefined))
+// CreatePasswordResetToken issues a token to set the password of the user
+// without the current one, replacing the previous tokens of the user.
+func (s userService) CreatePasswordResetToken(userID int, expiresIn time.Duration) (*models.PasswordResetToken, error) {
+	_, err := s.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.DB.Exec("DELETE FROM password_reset_tokens WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	token := models.PasswordResetToken{
+		Token:   uuid.NewV4().String(),
+		UserID:  userID,
+		Expires: time.Now().UTC().Add(expiresIn),
+	}
+	_, err = s.DB.Exec("INSERT INTO password_reset_tokens (token, user_id, expires) VALUES ($1, $2, $3)", hashToken(token.Token), token.UserID, token.Expires)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// ResetUserPassword consumes the password reset token and sets the password
+// of its user. The new password is validated first, so that a rejected
+// password does not use up the token.
+func (s userService) ResetUserPassword(token string, newPassword string) (*models.User, error) {
+	email := ""
+	err := s.DB.Get(&email, "SELECT users.email FROM password_reset_tokens JOIN users ON users.id = password_reset_tokens.user_id WHERE token = $1 AND expires > NOW() AND users.deleted_at IS NULL", hashToken(token))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == sql.ErrNoRows {
+		return nil, PasswordResetTokenInvalidError{}
+	}
+
+	err = s.PasswordPolicy.ValidatePassword(email, newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	userID := 0
+	err = s.DB.Get(&userID, "DELETE FROM password_reset_tokens WHERE token = $1 AND expires > NOW() RETURNING user_id", hashToken(token))
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
+	if err == sql.ErrNoRows {
+		return nil, PasswordResetTokenInvalidError{}
+	}
+
+	err = s.setUserPassword(userID, newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetUser(userID)
+}
+
+// setUserPassword stores the hash of the password and lifts the lockout of
+// the user, failed guesses of the old password do not matter anymore.
+func (s userService) setUserPassword(userID int, password string) error {
+	passwordHash, err := s.PasswordHasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec("UPDATE users SET password = $1 WHERE id = $2", passwordHash, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec("DELETE FROM email_login_attempts WHERE email = (SELECT lower(email) FROM users WHERE id = $1)", userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteUser soft deletes the user: the user is hidden and can not log in,
+// but is kept with their resources until purged, and can be restored.
+func (s userService) DeleteUser(userID int) error {
+	deletedUserID := 0
+	err := s.DB.Get(&deletedUserID, "UPDATE users SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL RETURNING id", time.Now().UTC(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RestoreUser undoes the soft deletion of the user. It fails with
+// UserEmailExistsError if the email was taken by another user meanwhile.
+func (s userService) RestoreUser(userID int) (*models.User, error) {
+	restoredUserID := 0
+	err := s.DB.Get(&restoredUserID, "UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL RETURNING id", userID)
+	if err != nil {
+		if isEmailExistsError(err) {
+			return nil, UserEmailExistsError{}
+		}
+		return nil, err
+	}
+
+	return s.GetUser(userID)
+}
+
+// PurgeDeletedUsers hard deletes the users soft deleted longer than
+// retention ago, with their resources and tokens.
+func (s userService) PurgeDeletedUsers(retention time.Duration) error {
+	_, err := s.DB.Exec("DELETE FROM users WHERE deleted_at < $1", time.Now().UTC().Add(-retention))
+	return err
+}
+
+// ListUsers returns a page of the users matching the options, and the cursor
+// of the next page, nil on the last page. Pages are read with a keyset on the
+// sort key and the id, so users created or deleted meanwhile do not shift the
+// next pages.
+func (s userService) ListUsers(options models.UserListOptions) ([]models.User, *models.UserCursor, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	args := []interface{}{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if options.Status != "" {
+		conditions = append(conditions, "status = "+arg(options.Status))
+	}
+	if options.Admin != nil {
+		conditions = append(conditions, "admin = "+arg(*options.Admin))
+	}
+	if options.EmailPrefix != "" {
+		conditions = append(conditions, "lower(email) LIKE "+arg(likePrefix(strings.ToLower(options.EmailPrefix))))
+	}
+	if options.MinQuota != nil {
+		conditions = append(conditions, "quota >= "+arg(*options.MinQuota))
+	}
+	if options.MaxQuota != nil {
+		conditions = append(conditions, "quota <= "+arg(*options.MaxQuota))
+	}
 
-	return users, nil
+	direction, comparison := "ASC", ">"
+	if options.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	var order string
+	switch options.Sort {
+	case models.UserSortEmail:
+		// Emails are unique, they need no tie break.
+		order = "lower(email) " + direction
+		if options.Cursor != nil {
+			conditions = append(conditions, "lower(email) "+comparison+" "+arg(options.Cursor.Email))
+		}
+	case models.UserSortCreatedAt:
+		order = "created_at " + direction + ", id " + direction
+		if options.Cursor != nil {
+			conditions = append(conditions, "(created_at, id) "+comparison+" ("+arg(*options.Cursor.CreatedAt)+", "+arg(options.Cursor.ID)+")")
+		}
+	default:
+		order = "id " + direction
+		if options.Cursor != nil {
+			conditions = append(conditions, "id "+comparison+" "+arg(options.Cursor.ID))
+		}
+	}
+
+	// One more user than the page tells whether there is a next page.
+	limit := ""
+	if options.Limit > 0 {
+		limit = " LIMIT " + arg(options.Limit+1)
+	}
+
+	users := []models.User{}
+	err := s.DB.Select(&users, "SELECT "+userColumns+" FROM users WHERE "+strings.Join(conditions, " AND ")+" ORDER BY "+order+limit, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+
+	if options.Limit == 0 || len(users) <= options.Limit {
+		return users, nil, nil
+	}
+
+	users = users[:options.Limit]
+	cursor := models.NewUserCursor(options, users[len(users)-1])
+	return users, &cursor, nil
 }
 
-func (s userService) AuthenticateUser(email string, password string) (*models.User, error) {
+// likePrefix returns a LIKE pattern matching the strings starting with
+// prefix, with the wildcards of prefix escaped.
+func likePrefix(prefix string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return replacer.Replace(prefix) + "%"
+}
+
+// AuthenticateUser returns the user with the email and password, or nil if
+// they are invalid. Locked emails and ips are rejected with UserLockedError
+// before the password is checked, suspended users with UserSuspendedError
+// after, so that suspension does not tell which emails are registered. An
+// empty ip is not throttled, for callers other than login requests. The
+// failed logins of users with two-factor authentication are kept until the
+// caller checked the one-time code and calls ResetFailedLogins, so that a
+// known password does not allow guessing codes forever.
+func (s userService) AuthenticateUser(email string, password string, ip string) (*models.User, error) {
+	err := s.CheckLoginLockout(email, ip)
+	if err != nil {
+		return nil, err
+	}
+
 	user := models.User{}
-	err := s.DB.Get(&user, fmt.Sprintf("SELECT id, email, password, admin, COALESCE(quota, %d) as quota FROM users WHERE lower(email) = lower($1)", UserQuotaUndefined), email)
+	err = s.DB.Get(&user, "SELECT password, "+userColumns+" FROM users WHERE lower(email) = lower($1) AND deleted_at IS NULL", email)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+	if !checkPassword(user.Password, s.DummyPasswordHash, password) {
+		err = s.RecordFailedLogin(email, ip)
+		if err != nil {
+			return nil, err
+		}
+
 		return nil, nil
 	}
 
+	if user.Status == models.UserStatusSuspended {
+		return nil, UserSuspendedError{}
+	}
+
+	if user.FailedLoginAttempts > 0 && !user.MFAEnabled {
+		err = s.ResetFailedLogins(user.Email)
+		if err != nil {
+			return nil, err
+		}
+		user.FailedLoginAttempts = 0
+	}
+
+	// The password is only known now, upgrade hashes of an older algorithm
+	// or weaker parameters. A password changed meanwhile is left alone.
+	if s.PasswordHasher.NeedsRehash(user.Password) {
+		passwordHash, err := s.PasswordHasher.Hash(password)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = s.DB.Exec("UPDATE users SET password = $1 WHERE id = $2 AND password = $3", passwordHash, user.ID, user.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	user.Password = ""
 
 	return &user, nil
 }
 
-func NewUserService(db *sqlx.DB) UserService {
+// CheckLoginLockout returns UserLockedError if the email or the ip is locked
+// out. Emails are locked out whether a user has them or not. An empty ip is
+// not checked.
+func (s userService) CheckLoginLockout(email string, ip string) error {
+	var lockedUntil time.Time
+	err := s.DB.Get(&lockedUntil, "SELECT locked_until FROM email_login_attempts WHERE email = $1 AND locked_until > NOW()", normalizeLoginEmail(email))
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil {
+		return UserLockedError{LockedUntil: lockedUntil}
+	}
+
+	if ip != "" {
+		err = s.DB.Get(&lockedUntil, "SELECT locked_until FROM ip_login_attempts WHERE ip = $1 AND locked_until > NOW()", ip)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil {
+			return UserLockedError{LockedUntil: lockedUntil}
+		}
+	}
+
+	return nil
+}
+
+// RecordFailedLogin counts a failed login against the email, whether a user
+// has it or not, and against the ip, and locks them out. Logins failing after
+// the password, such as with an invalid one-time code, count as well.
+func (s userService) RecordFailedLogin(email string, ip string) error {
+	err := s.countFailedLogin("email_login_attempts", "email", normalizeLoginEmail(email), s.Lockout.MaxAttempts)
+	if err != nil {
+		return err
+	}
+
+	if ip != "" {
+		err = s.countFailedLogin("ip_login_attempts", "ip", ip, s.Lockout.IPMaxAttempts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// countFailedLogin counts a failed login of the key in the table of login
+// attempts and locks it out. Counts are forgotten a lockout duration after
+// the last lockout ended.
+func (s userService) countFailedLogin(table string, column string, key string, maxAttempts int) error {
+	now := time.Now().UTC()
+	forgetBefore := now.Add(-s.Lockout.Duration)
+
+	attempts := 0
+	err := s.DB.Get(&attempts, "INSERT INTO "+table+" ("+column+", failed_login_attempts, locked_until) VALUES ($1, 1, $2) ON CONFLICT ("+column+") DO UPDATE SET failed_login_attempts = CASE WHEN "+table+".locked_until > $3 THEN "+table+".failed_login_attempts + 1 ELSE 1 END RETURNING failed_login_attempts", key, now, forgetBefore)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec("UPDATE "+table+" SET locked_until = $2 WHERE "+column+" = $1", key, now.Add(s.Lockout.delay(attempts, maxAttempts)))
+	return err
+}
+
+// ResetFailedLogins forgets the failed logins of the email after a successful
+// login.
+func (s userService) ResetFailedLogins(email string) error {
+	_, err := s.DB.Exec("DELETE FROM email_login_attempts WHERE email = $1", normalizeLoginEmail(email))
+	return err
+}
+
+// normalizeLoginEmail returns the key of the failed logins of an email,
+// emails are case insensitive.
+func normalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// CleanExpiredLoginAttempts deletes the failed logins of emails and ips that
+// are forgotten already.
+func (s userService) CleanExpiredLoginAttempts() error {
+	forgetBefore := time.Now().UTC().Add(-s.Lockout.Duration)
+	_, err := s.DB.Exec("DELETE FROM email_login_attempts WHERE locked_until < $1", forgetBefore)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec("DELETE FROM ip_login_attempts WHERE locked_until < $1", forgetBefore)
+	return err
+}
+
+func (s userService) CleanExpiredPasswordResetTokens() error {
+	_, err := s.DB.Exec("DELETE FROM password_reset_tokens WHERE expires < NOW()")
+	return err
+}
+
+// emailExistsError returns a validation error if err is the violation of the
+// unique email index, err otherwise.
+func emailExistsError(err error) error {
+	if isEmailExistsError(err) {
+		return models.UserValidationError{
+			Field:  "email",
+			Reason: fmt.Sprintf("user with email already exists"),
+		}
+	}
+
+	return err
+}
+
+func isEmailExistsError(err error) bool {
+	return strings.Contains(err.Error(), "users_unique_lower_email_idx")
+}
+
+func NewUserService(db *sqlx.DB, lockout LoginLockout, passwordPolicy models.PasswordPolicy, passwordHasher PasswordHasher) UserService {
+	dummyPasswordHash, _ := passwordHasher.Hash("dummy password")
+
 	return &userService{
-		DB: db,
+		DB:                db,
+		Lockout:           lockout,
+		PasswordPolicy:    passwordPolicy,
+		PasswordHasher:    passwordHasher,
+		DummyPasswordHash: dummyPasswordHash,
 	}
 }
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/moonkeat/chainstack/models"
+)
+
+func TestAuthenticateUserFailedLogins(t *testing.T) {
+	hasher := BcryptHasher{Cost: bcrypt.MinCost}
+	passwordHash, err := hasher.Hash("correctpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// mfa@email.com has two-factor authentication enabled, user@email.com
+	// not. Failed logins lock out an email from the max attempts on.
+	failedLogins := map[string]int64{}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, "SELECT locked_until FROM email_login_attempts "):
+			if failedLogins[args[0].(string)] >= int64(DefaultLoginLockout.MaxAttempts) {
+				return []string{"locked_until"}, [][]driver.Value{{time.Now().Add(time.Minute)}}
+			}
+		case strings.HasPrefix(query, "SELECT password, "):
+			email := args[0].(string)
+			return []string{"password", "id", "email", "failed_login_attempts", "mfa_enabled", "status"},
+				[][]driver.Value{{passwordHash, int64(1), email, failedLogins[email], email == "mfa@email.com", models.UserStatusActive}}
+		case strings.HasPrefix(query, "INSERT INTO email_login_attempts "):
+			failedLogins[args[0].(string)]++
+			return []string{"failed_login_attempts"}, [][]driver.Value{{failedLogins[args[0].(string)]}}
+		case strings.HasPrefix(query, "DELETE FROM email_login_attempts "):
+			delete(failedLogins, args[0].(string))
+		}
+		return nil, nil
+	})
+	userService := NewUserService(db, DefaultLoginLockout, models.DefaultPasswordRules, hasher)
+
+	// Should forget the failed logins of a user without two-factor
+	// authentication once the password is correct
+	for i := 0; i < 3; i++ {
+		userService.RecordFailedLogin("user@email.com", "")
+	}
+	user, err := userService.AuthenticateUser("user@email.com", "correctpassword", "")
+	if err != nil || user == nil {
+		t.Fatalf("user not authenticated: %v, %v", user, err)
+	}
+	if failedLogins["user@email.com"] != 0 {
+		t.Errorf("failed logins not forgotten: %d", failedLogins["user@email.com"])
+	}
+
+	// Should keep the failed logins of a user with two-factor authentication,
+	// so that invalid codes after the correct password lock the email out
+	for i := 0; i < DefaultLoginLockout.MaxAttempts; i++ {
+		user, err = userService.AuthenticateUser("mfa@email.com", "correctpassword", "")
+		if err != nil || user == nil {
+			t.Fatalf("user not authenticated after %d invalid codes: %v, %v", i, user, err)
+		}
+		if user.FailedLoginAttempts != i {
+			t.Errorf("user has %d failed logins after %d invalid codes", user.FailedLoginAttempts, i)
+		}
+		userService.RecordFailedLogin("mfa@email.com", "")
+	}
+	_, err = userService.AuthenticateUser("mfa@email.com", "correctpassword", "")
+	if _, ok := err.(UserLockedError); !ok {
+		t.Errorf("user not locked out after %d invalid codes: %v", DefaultLoginLockout.MaxAttempts, err)
+	}
+
+	// Should forget them once the caller checked the code
+	err = userService.ResetFailedLogins("MFA@email.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = userService.AuthenticateUser("mfa@email.com", "correctpassword", "")
+	if err != nil || user == nil || user.FailedLoginAttempts != 0 {
+		t.Errorf("failed logins not forgotten: %v, %v", user, err)
+	}
+}
+
+func TestCreateUserLockedEmail(t *testing.T) {
+	// Every email is locked out, and nothing but the insert and the read of
+	// the new user is expected.
+	queries := []string{}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		queries = append(queries, query)
+		switch {
+		case strings.HasPrefix(query, "SELECT locked_until FROM email_login_attempts "):
+			return []string{"locked_until"}, [][]driver.Value{{time.Now().Add(time.Minute)}}
+		case strings.HasPrefix(query, "INSERT INTO users "):
+			return []string{"id"}, [][]driver.Value{{int64(7)}}
+		case strings.HasPrefix(query, "SELECT "+userColumns+" FROM users WHERE id = $1 "):
+			return []string{"id", "email", "status"}, [][]driver.Value{{args[0], "locked@email.com", models.UserStatusActive}}
+		}
+		return nil, nil
+	})
+	userService := NewUserService(db, DefaultLoginLockout, models.DefaultPasswordRules, BcryptHasher{Cost: bcrypt.MinCost})
+
+	// Should return the new user even if failed logins locked out its email,
+	// without logging in
+	user, err := userService.CreateUser("locked@email.com", "correctpassword", false, nil, nil)
+	if err != nil {
+		t.Fatalf("user not created: %v", err)
+	}
+	if user.ID != 7 || user.Email != "locked@email.com" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if len(queries) != 2 {
+		t.Errorf("unexpected queries: %v", queries)
+	}
+}
+
+func TestListUsersLimit(t *testing.T) {
+	// The fake database holds three users, and returns them regardless of
+	// the limit of the query.
+	var listQuery string
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		listQuery = query
+		return []string{"id", "email", "status"}, [][]driver.Value{
+			{int64(1), "a@email.com", models.UserStatusActive},
+			{int64(2), "b@email.com", models.UserStatusActive},
+			{int64(3), "c@email.com", models.UserStatusActive},
+		}
+	})
+	userService := NewUserService(db, DefaultLoginLockout, models.DefaultPasswordRules, BcryptHasher{Cost: bcrypt.MinCost})
+
+	// Should list every user without a limit
+	users, next, err := userService.ListUsers(models.UserListOptions{Sort: models.UserSortID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 || next != nil || strings.Contains(listQuery, "LIMIT") {
+		t.Errorf("users not listed at once: %v, %v, %s", users, next, listQuery)
+	}
+
+	// Should list a page of users with a limit
+	users, next, err = userService.ListUsers(models.UserListOptions{Sort: models.UserSortID, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || next == nil || next.ID != 2 || !strings.Contains(listQuery, "LIMIT") {
+		t.Errorf("users not paginated: %v, %v, %s", users, next, listQuery)
+	}
+}